@@ -2,12 +2,13 @@ package blockartlib
 
 import (
 	"crypto/ecdsa"
-	"crypto/md5"
+	"crypto/x509"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"net/rpc"
 	"sort"
 	"strconv"
@@ -16,11 +17,15 @@ import (
 )
 
 type CanvasInstance struct {
-	minerAddr string
-	privKey   ecdsa.PrivateKey
-	client    *rpc.Client
-	settings  CanvasSettings
-	closed    bool
+	minerAddr   string
+	privKey     ecdsa.PrivateKey
+	client      *rpc.Client
+	settings    CanvasSettings
+	netSettings MinerNetSettings // the parts of the network's settings known to the library
+	verifying   bool             // true if the library verifies what the miner tells it
+	headers     *headerCache     // headers of the miner's longest chain verified so far, in verifying mode
+	canvasID    string           // canvas the app works on; empty for the network's own canvas
+	closed      bool
 }
 
 const MAX_SVG_LENGTH = 128
@@ -37,34 +42,125 @@ type Box struct {
 
 // Public Methods
 func (canvas CanvasInstance) AddShape(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return canvas.AddShapeWithOptions(validateNum, shapeType, shapeSvgString, fill, stroke, OpOptions{})
+}
+
+// Adds a shape to the canvas, paying options.Fee to the miner that includes it
+// @param canvas CanvasInstance
+// @return string, string, uint32, error
+func (canvas CanvasInstance) AddShapeWithOptions(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string, options OpOptions) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
 	if canvas.closed {
 		return shapeHash, blockHash, inkRemaining, DisconnectedError(canvas.minerAddr)
 	}
 
-	shape, err := convertShape(shapeType, shapeSvgString, fill, stroke)
+	shape, err := convertShape(shapeType, shapeSvgString, fill, stroke, options, canvas.netSettings.InkPricing)
 	if err != nil {
 		return shapeHash, blockHash, inkRemaining, err
 	}
+	shape.Layer = options.Layer
+	shape.Canvas = canvas.canvasID
 
 	// register any errors this might receive
 	gob.Register(DisconnectedError(""))
 	gob.Register(InsufficientInkError(0))
 	gob.Register(InvalidShapeSvgStringError(""))
+	gob.Register(InvalidColorError(""))
+	gob.Register(InvalidTransformError(""))
 	gob.Register(ShapeSvgStringTooLongError(""))
 	gob.Register(ShapeOverlapError(""))
+	gob.Register(RegionClaimedError(""))
+	gob.Register(InvalidCanvasError(""))
 	gob.Register(OutOfBoundsError{})
+	gob.Register(MempoolFullError(""))
 
-	hash := HashShape(*shape)
+	hash := HashShape(*shape, canvas.netSettings.BlockVersion)
 	shapeMeta := ShapeMeta{Hash: hash, Shape: *shape}
 
 	args := AddShapeArgs{
 		ShapeMeta:   shapeMeta,
-		ValidateNum: validateNum}
+		ValidateNum: validateNum,
+		Fee:         options.Fee}
 	var reply AddShapeReply
 	if err = canvas.client.Call("LibMin.AddShapeIM", args, &reply); err != nil {
 		return shapeHash, blockHash, inkRemaining, DisconnectedError(canvas.minerAddr)
 	}
 
+	if reply.Error == nil && canvas.verifying {
+		// don't take the miner's word that the shape was added
+		proof, err := canvas.GetShapeProof(hash)
+		if err != nil {
+			return shapeHash, blockHash, inkRemaining, err
+		}
+		if err = canvas.verifyShapeProof(hash, proof, validateNum); err != nil {
+			return shapeHash, blockHash, inkRemaining, err
+		}
+		return hash, proof.BlockHash, reply.InkRemaining, nil
+	}
+
+	return hash, reply.BlockHash, reply.InkRemaining, reply.Error
+}
+
+// Replaces a shape on the canvas by a new one
+// @param canvas CanvasInstance
+// @return string, string, uint32, error
+func (canvas CanvasInstance) UpdateShape(validateNum uint8, shapeHash string, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (newShapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return canvas.UpdateShapeWithOptions(validateNum, shapeHash, shapeType, shapeSvgString, fill, stroke, OpOptions{})
+}
+
+// Replaces a shape on the canvas by a new one, paying options.Fee to the miner that includes it
+// @param canvas CanvasInstance
+// @return string, string, uint32, error
+func (canvas CanvasInstance) UpdateShapeWithOptions(validateNum uint8, shapeHash string, shapeType ShapeType, shapeSvgString string, fill string, stroke string, options OpOptions) (newShapeHash string, blockHash string, inkRemaining uint32, err error) {
+	if canvas.closed {
+		return newShapeHash, blockHash, inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	shape, err := convertShape(shapeType, shapeSvgString, fill, stroke, options, canvas.netSettings.InkPricing)
+	if err != nil {
+		return newShapeHash, blockHash, inkRemaining, err
+	}
+	shape.Layer = options.Layer
+	shape.Canvas = canvas.canvasID
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(InvalidShapeSvgStringError(""))
+	gob.Register(InvalidColorError(""))
+	gob.Register(InvalidTransformError(""))
+	gob.Register(ShapeSvgStringTooLongError(""))
+	gob.Register(ShapeOverlapError(""))
+	gob.Register(RegionClaimedError(""))
+	gob.Register(InvalidCanvasError(""))
+	gob.Register(ShapeOwnerError(""))
+	gob.Register(OutOfBoundsError{})
+	gob.Register(MempoolFullError(""))
+
+	hash := HashShape(*shape, canvas.netSettings.BlockVersion)
+	shapeMeta := ShapeMeta{Hash: hash, Shape: *shape}
+
+	args := UpdateShapeArgs{
+		ShapeHash:   shapeHash,
+		ShapeMeta:   shapeMeta,
+		ValidateNum: validateNum,
+		Fee:         options.Fee}
+	var reply UpdateShapeReply
+	if err = canvas.client.Call("LibMin.UpdateShapeIM", args, &reply); err != nil {
+		return newShapeHash, blockHash, inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	if reply.Error == nil && canvas.verifying {
+		// don't take the miner's word that the shape was added
+		proof, err := canvas.GetShapeProof(hash)
+		if err != nil {
+			return newShapeHash, blockHash, inkRemaining, err
+		}
+		if err = canvas.verifyShapeProof(hash, proof, validateNum); err != nil {
+			return newShapeHash, blockHash, inkRemaining, err
+		}
+		return hash, proof.BlockHash, reply.InkRemaining, nil
+	}
+
 	return hash, reply.BlockHash, reply.InkRemaining, reply.Error
 }
 
@@ -115,6 +211,13 @@ func (canvas CanvasInstance) GetInk() (inkRemaining uint32, err error) {
 // @param canvas CanvasInstance
 // @return uint8, string
 func (canvas CanvasInstance) DeleteShape(validateNum uint8, shapeHash string) (inkRemaining uint32, err error) {
+	return canvas.DeleteShapeWithOptions(validateNum, shapeHash, OpOptions{})
+}
+
+// Deletes shape from canvas, paying options.Fee to the miner that includes the deletion
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) DeleteShapeWithOptions(validateNum uint8, shapeHash string, options OpOptions) (inkRemaining uint32, err error) {
 	if canvas.closed {
 		return inkRemaining, DisconnectedError(canvas.minerAddr)
 	}
@@ -122,8 +225,10 @@ func (canvas CanvasInstance) DeleteShape(validateNum uint8, shapeHash string) (i
 	// register any errors this might receive
 	gob.Register(DisconnectedError(""))
 	gob.Register(ShapeOwnerError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(MempoolFullError(""))
 
-	args := &DeleteShapeArgs{ValidateNum: validateNum, ShapeHash: shapeHash}
+	args := &DeleteShapeArgs{ValidateNum: validateNum, ShapeHash: shapeHash, Fee: options.Fee}
 	var reply DeleteShapeReply
 	if canvas.client.Call("LibMin.DeleteShapeIM", args, &reply); err != nil {
 		return inkRemaining, DisconnectedError(canvas.minerAddr)
@@ -132,6 +237,274 @@ func (canvas CanvasInstance) DeleteShape(validateNum uint8, shapeHash string) (i
 	return reply.InkRemaining, reply.Error
 }
 
+// Transfers ink to another miner and returns the new remaining ink count
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) TransferInk(toPubKey ecdsa.PublicKey, amount uint32, validateNum uint8) (inkRemaining uint32, err error) {
+	return canvas.TransferInkWithOptions(toPubKey, amount, validateNum, OpOptions{})
+}
+
+// Transfers ink to another miner, paying options.Fee to the miner that includes the transfer
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) TransferInkWithOptions(toPubKey ecdsa.PublicKey, amount uint32, validateNum uint8, options OpOptions) (inkRemaining uint32, err error) {
+	if canvas.closed {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	// miners identify each other by the hex encoding of their public keys
+	pub, err := x509.MarshalPKIXPublicKey(&toPubKey)
+	if err != nil {
+		return inkRemaining, InvalidTransferError("")
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(InvalidTransferError(""))
+	gob.Register(MempoolFullError(""))
+
+	args := &TransferInkArgs{To: hex.EncodeToString(pub), Amount: amount, ValidateNum: validateNum, Fee: options.Fee}
+	var reply TransferInkReply
+	if err = canvas.client.Call("LibMin.TransferInkIM", args, &reply); err != nil {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.InkRemaining, reply.Error
+}
+
+// Hands a shape to another miner and returns the new remaining ink count
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) TransferShape(shapeHash string, toPubKey ecdsa.PublicKey, validateNum uint8) (inkRemaining uint32, err error) {
+	return canvas.TransferShapeWithOptions(shapeHash, toPubKey, validateNum, OpOptions{})
+}
+
+// Hands a shape to another miner, paying options.Fee to the miner that includes the transfer
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) TransferShapeWithOptions(shapeHash string, toPubKey ecdsa.PublicKey, validateNum uint8, options OpOptions) (inkRemaining uint32, err error) {
+	if canvas.closed {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	// miners identify each other by the hex encoding of their public keys
+	pub, err := x509.MarshalPKIXPublicKey(&toPubKey)
+	if err != nil {
+		return inkRemaining, InvalidTransferError("")
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(ShapeOwnerError(""))
+	gob.Register(InvalidTransferError(""))
+	gob.Register(ShapeOverlapError(""))
+	gob.Register(MempoolFullError(""))
+
+	args := &TransferShapeArgs{ShapeHash: shapeHash, To: hex.EncodeToString(pub), ValidateNum: validateNum, Fee: options.Fee}
+	var reply TransferShapeReply
+	if err = canvas.client.Call("LibMin.TransferShapeIM", args, &reply); err != nil {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.InkRemaining, reply.Error
+}
+
+// Claims a region of the canvas and returns the claim's hash and the new remaining ink count
+// @param canvas CanvasInstance
+// @return string, uint32, error
+func (canvas CanvasInstance) ClaimRegion(region Box, blocks uint32, deposit uint32, validateNum uint8) (claimHash string, inkRemaining uint32, err error) {
+	return canvas.ClaimRegionWithOptions(region, blocks, deposit, validateNum, OpOptions{})
+}
+
+// Claims a region of the canvas, paying options.Fee to the miner that includes the claim
+// @param canvas CanvasInstance
+// @return string, uint32, error
+func (canvas CanvasInstance) ClaimRegionWithOptions(region Box, blocks uint32, deposit uint32, validateNum uint8, options OpOptions) (claimHash string, inkRemaining uint32, err error) {
+	if canvas.closed {
+		return claimHash, inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(InvalidClaimError(""))
+	gob.Register(RegionClaimedError(""))
+	gob.Register(InvalidCanvasError(""))
+	gob.Register(MempoolFullError(""))
+
+	args := &ClaimRegionArgs{CanvasID: canvas.canvasID, Region: region, Blocks: blocks, Deposit: deposit, ValidateNum: validateNum, Fee: options.Fee}
+	var reply ClaimRegionReply
+	if err = canvas.client.Call("LibMin.ClaimRegionIM", args, &reply); err != nil {
+		return claimHash, inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.ClaimHash, reply.InkRemaining, reply.Error
+}
+
+// Lets another miner draw in a claimed region and returns the new remaining ink count
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) GrantRegion(claimHash string, toPubKey ecdsa.PublicKey, validateNum uint8) (inkRemaining uint32, err error) {
+	return canvas.GrantRegionWithOptions(claimHash, toPubKey, validateNum, OpOptions{})
+}
+
+// Lets another miner draw in a claimed region, paying options.Fee to the miner that includes the grant
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) GrantRegionWithOptions(claimHash string, toPubKey ecdsa.PublicKey, validateNum uint8, options OpOptions) (inkRemaining uint32, err error) {
+	if canvas.closed {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	// miners identify each other by the hex encoding of their public keys
+	pub, err := x509.MarshalPKIXPublicKey(&toPubKey)
+	if err != nil {
+		return inkRemaining, InvalidTransferError("")
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(InvalidClaimError(""))
+	gob.Register(InvalidTransferError(""))
+	gob.Register(MempoolFullError(""))
+
+	args := &GrantRegionArgs{ClaimHash: claimHash, To: hex.EncodeToString(pub), ValidateNum: validateNum, Fee: options.Fee}
+	var reply GrantRegionReply
+	if err = canvas.client.Call("LibMin.GrantRegionIM", args, &reply); err != nil {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.InkRemaining, reply.Error
+}
+
+// Creates a new canvas on the network and returns the new remaining ink count
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) CreateCanvas(canvasID string, settings CanvasSettings, rules CanvasRules, validateNum uint8) (inkRemaining uint32, err error) {
+	return canvas.CreateCanvasWithOptions(canvasID, settings, rules, validateNum, OpOptions{})
+}
+
+// Creates a new canvas on the network, paying options.Fee to the miner that includes the creation
+// @param canvas CanvasInstance
+// @return uint32, error
+func (canvas CanvasInstance) CreateCanvasWithOptions(canvasID string, settings CanvasSettings, rules CanvasRules, validateNum uint8, options OpOptions) (inkRemaining uint32, err error) {
+	if canvas.closed {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(InvalidCanvasError(""))
+	gob.Register(MempoolFullError(""))
+
+	args := &CreateCanvasArgs{CanvasID: canvasID, Settings: settings, Rules: rules, ValidateNum: validateNum, Fee: options.Fee}
+	var reply CreateCanvasReply
+	if err = canvas.client.Call("LibMin.CreateCanvasIM", args, &reply); err != nil {
+		return inkRemaining, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.InkRemaining, reply.Error
+}
+
+// Checks a shape against the canvas without adding it, and returns its ink and bounding box
+// @param canvas CanvasInstance
+// @return uint32, Box, error
+func (canvas CanvasInstance) ValidateShape(shapeType ShapeType, shapeSvgString string, fill string, stroke string) (ink uint32, bounds Box, err error) {
+	return canvas.ValidateShapeWithOptions(shapeType, shapeSvgString, fill, stroke, OpOptions{})
+}
+
+// Checks a shape against the canvas, as if it were added with the passed options, without adding it
+// @param canvas CanvasInstance
+// @return uint32, Box, error
+func (canvas CanvasInstance) ValidateShapeWithOptions(shapeType ShapeType, shapeSvgString string, fill string, stroke string, options OpOptions) (ink uint32, bounds Box, err error) {
+	if canvas.closed {
+		return ink, bounds, DisconnectedError(canvas.minerAddr)
+	}
+
+	shape, err := convertShape(shapeType, shapeSvgString, fill, stroke, options, canvas.netSettings.InkPricing)
+	if err != nil {
+		return ink, bounds, err
+	}
+	shape.Layer = options.Layer
+	shape.Canvas = canvas.canvasID
+	ink = shape.Ink
+	bounds = ShapeBounds(*shape)
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InsufficientInkError(0))
+	gob.Register(InvalidShapeSvgStringError(""))
+	gob.Register(InvalidColorError(""))
+	gob.Register(InvalidTransformError(""))
+	gob.Register(ShapeSvgStringTooLongError(""))
+	gob.Register(ShapeOverlapError(""))
+	gob.Register(RegionClaimedError(""))
+	gob.Register(InvalidCanvasError(""))
+	gob.Register(OutOfBoundsError{})
+
+	hash := HashShape(*shape, canvas.netSettings.BlockVersion)
+	args := ValidateShapeArgs{ShapeMeta: ShapeMeta{Hash: hash, Shape: *shape}, Fee: options.Fee}
+	var reply ValidateShapeReply
+	if err = canvas.client.Call("LibMin.ValidateShapeIM", args, &reply); err != nil {
+		return ink, bounds, DisconnectedError(canvas.minerAddr)
+	}
+
+	return ink, bounds, reply.Error
+}
+
+// Finds places on the canvas where a box of the passed size can be drawn without overlapping others' shapes
+// @param canvas CanvasInstance
+// @return []Point, error
+func (canvas CanvasInstance) FindFreeSpace(width float64, height float64, maxResults int) (positions []Point, err error) {
+	return canvas.FindFreeSpaceWithOptions(width, height, maxResults, OpOptions{})
+}
+
+// Finds places on options.Layer of the canvas where a box of the passed size can be drawn
+// @param canvas CanvasInstance
+// @return []Point, error
+func (canvas CanvasInstance) FindFreeSpaceWithOptions(width float64, height float64, maxResults int, options OpOptions) (positions []Point, err error) {
+	if canvas.closed {
+		return positions, DisconnectedError(canvas.minerAddr)
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InvalidCanvasError(""))
+	gob.Register(OutOfBoundsError{})
+
+	args := FindFreeSpaceArgs{CanvasID: canvas.canvasID, Layer: options.Layer, Width: width, Height: height, MaxResults: maxResults}
+	var reply FindFreeSpaceReply
+	if err = canvas.client.Call("LibMin.FindFreeSpaceIM", args, &reply); err != nil {
+		return positions, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.Positions, reply.Error
+}
+
+// Gets the current owner and ink of a shape
+// @param canvas CanvasInstance
+// @return ShapeInfo, error
+func (canvas CanvasInstance) GetShapeInfo(shapeHash string) (info ShapeInfo, err error) {
+	if canvas.closed {
+		return info, DisconnectedError(canvas.minerAddr)
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InvalidShapeHashError(""))
+
+	var reply GetShapeInfoReply
+	if err = canvas.client.Call("LibMin.GetShapeInfoIM", &shapeHash, &reply); err != nil {
+		return info, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.Info, reply.Error
+}
+
 // Gets the shapes' hashes from a hashed block
 // @param canvas CanvasInstance
 // @return []string, error
@@ -192,6 +565,65 @@ func (canvas CanvasInstance) GetChildren(blockHash string) (blockHashes []string
 	return reply.BlockHashes, reply.Error
 }
 
+// Gets the header of a hashed block
+// @param canvas CanvasInstance
+// @return BlockHeader, error
+func (canvas CanvasInstance) GetBlockHeader(blockHash string) (header BlockHeader, err error) {
+	if canvas.closed {
+		return header, DisconnectedError(canvas.minerAddr)
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InvalidBlockHashError(""))
+
+	var reply GetBlockHeaderReply
+	if err = canvas.client.Call("LibMin.GetBlockHeaderIM", &blockHash, &reply); err != nil {
+		return header, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.Header, reply.Error
+}
+
+// Gets a proof that the hashed shape is in a block on the longest chain
+// @param canvas CanvasInstance
+// @return ShapeProof, error
+func (canvas CanvasInstance) GetShapeProof(shapeHash string) (proof ShapeProof, err error) {
+	if canvas.closed {
+		return proof, DisconnectedError(canvas.minerAddr)
+	}
+
+	// register any errors this might receive
+	gob.Register(DisconnectedError(""))
+	gob.Register(InvalidShapeHashError(""))
+
+	var reply GetShapeProofReply
+	if err = canvas.client.Call("LibMin.GetShapeProofIM", &shapeHash, &reply); err != nil {
+		return proof, DisconnectedError(canvas.minerAddr)
+	}
+
+	return reply.Proof, reply.Error
+}
+
+// Verifies that the hashed shape is on the longest chain without trusting the miner
+// @param canvas CanvasInstance
+// @return string, error
+func (canvas CanvasInstance) VerifyShape(shapeHash string) (blockHash string, err error) {
+	if canvas.closed {
+		return blockHash, DisconnectedError(canvas.minerAddr)
+	}
+
+	proof, err := canvas.GetShapeProof(shapeHash)
+	if err != nil {
+		return blockHash, err
+	}
+	if err = canvas.verifyShapeProof(shapeHash, proof, 0); err != nil {
+		return blockHash, err
+	}
+
+	return proof.BlockHash, nil
+}
+
 // Close the canvas
 // @param canvas CanvasInstance
 // @return uint32, error
@@ -217,29 +649,50 @@ func (canvas CanvasInstance) CloseCanvas() (inkRemaining uint32, err error) {
 	@param: shape string for the svg
 	@param: fill to determine if the svg shape needs to calculate area
 	@param: width of the stroke
+	@param: options: the fill rule and transform of the shape
 	@return: internal shape struct ; error otherwise
 */
-func convertShape(shapeType ShapeType, shapeSvgString string, fill string, stroke string) (*Shape, error) {
+func convertShape(shapeType ShapeType, shapeSvgString string, fill string, stroke string, options OpOptions, pricing InkPricing) (*Shape, error) {
 	var err error
 	var shape *Shape
+	// colors are stored, and hashed, in canonical form
+	fillColor, err := ParseColor(fill)
+	if err != nil {
+		return nil, err
+	}
+	strokeColor, err := ParseColor(stroke)
+	if err != nil {
+		return nil, err
+	}
 	if shapeType == PATH {
-		shape, err = svgToShape(shapeSvgString)
+		shape, err = svgToShape(shapeSvgString, options.Transform)
 		if err != nil {
 			return nil, err
 		}
 	} else if shapeType == CIRCLE {
-		shape, err = svgToCircleShape(shapeSvgString)
+		shape, err = svgToCircleShape(shapeSvgString, options.Transform)
 		if err != nil {
 			return nil, err
 		}
+	} else if shapeType == TEXT {
+		if !fillColor.Transparent {
+			return nil, InvalidShapeSvgStringError("Text can't be filled; use a transparent fill")
+		}
+		shape, err = svgToTextShape(shapeSvgString, options.Transform)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, InvalidShapeSvgStringError("Unknown shape type " + strconv.Itoa(int(shapeType)))
 	}
 	shape.Svg = shapeSvgString
-	shape.FilledIn = strings.ToLower(fill) != TRANSPARENT
-	shape.FillColor = fill
-	shape.BorderColor = stroke
+	shape.FilledIn = !fillColor.Transparent
+	shape.FillColor = fillColor.String()
+	shape.BorderColor = strokeColor.String()
+	shape.FillRule = options.FillRule
 	shape.Timestamp = time.Now().UnixNano()
 
-	shape.Ink, err = InkUsed(shape)
+	shape.Ink, err = InkUsedWithPricing(shape, pricing)
 	return shape, err
 }
 
@@ -247,12 +700,17 @@ func convertShape(shapeType ShapeType, shapeSvgString string, fill string, strok
 // @param svg string: In format "cx,cy,r"
 // - (cx, cy) = coordinate of the centre of the circle
 // - r = radius of circle
-func svgToCircleShape(svg string) (*Shape, error) {
+// @param transform string: transform applied to the circle; see ParseTransform
+func svgToCircleShape(svg string, transform string) (*Shape, error) {
 	if IsSvgTooLong(svg) {
 		return nil, ShapeSvgStringTooLongError(svg)
 	}
+	if IsSvgTooLong(transform) {
+		return nil, ShapeSvgStringTooLongError(transform)
+	}
 	shape := Shape{}
 	shape.IsCircle = true
+	shape.Transform = transform
 	// Remove all whitespace in string (just to be careful)
 	svg = strings.Replace(svg, " ", "", -1)
 	svgParts := strings.Split(svg, ",")
@@ -271,12 +729,39 @@ func svgToCircleShape(svg string) (*Shape, error) {
 	shape.Cy = cy
 	r, err := strconv.ParseFloat(svgParts[2], 64)
 	shape.Radius = r
+	if err := ApplyTransform(&shape); err != nil {
+		return nil, err
+	}
 	if !IsShapeInCanvas(shape) {
 		return nil, InvalidShapeSvgStringError(svg)
 	}
 	return &shape, nil
 }
 
+// Turn text svg string into shape
+// @param svg string: In format "x,y,size,text"; see ParseSvgText
+// @param transform string: transform applied to the text; see ParseTransform
+func svgToTextShape(svg string, transform string) (*Shape, error) {
+	if IsSvgTooLong(svg) {
+		return nil, ShapeSvgStringTooLongError(svg)
+	}
+	if IsSvgTooLong(transform) {
+		return nil, ShapeSvgStringTooLongError(transform)
+	}
+	shape, err := ParseSvgText(svg)
+	if err != nil {
+		return nil, err
+	}
+	shape.Transform = transform
+	if err := ApplyTransform(shape); err != nil {
+		return nil, err
+	}
+	if !IsShapeInCanvas(*shape) {
+		return nil, InvalidShapeSvgStringError(svg)
+	}
+	return shape, nil
+}
+
 /*
 	Checking for errors and printing the context
 	@param: svg path string
@@ -289,16 +774,24 @@ func IsSvgTooLong(svgString string) bool {
 /*
 	Wrapper to call parser, error detections
 	@param: svg string for path
+	@param: transform applied to the path; see ParseTransform
 	@return: shape that is parsed with the internal struct or error otherwise
 */
-func svgToShape(svgString string) (*Shape, error) {
+func svgToShape(svgString string, transform string) (*Shape, error) {
 	if IsSvgTooLong(svgString) {
 		return nil, ShapeSvgStringTooLongError(svgString)
 	}
+	if IsSvgTooLong(transform) {
+		return nil, ShapeSvgStringTooLongError(transform)
+	}
 	shape, err := ParseSvgPath(svgString)
 	if err != nil {
 		return nil, err
 	}
+	shape.Transform = transform
+	if err := ApplyTransform(shape); err != nil {
+		return nil, err
+	}
 	if !IsShapeInCanvas(*shape) {
 		return nil, InvalidShapeSvgStringError(svgString)
 	}
@@ -313,37 +806,31 @@ func svgToShape(svgString string) (*Shape, error) {
 func IsShapeInCanvas(shape Shape) bool {
 	canvasXMax := float64(canvasT.settings.CanvasXMax)
 	canvasYMax := float64(canvasT.settings.CanvasYMax)
+	// the comparisons are exact, and written so that NaN is out of the canvas
+	inRange := func(value float64, max float64) bool {
+		return 0 <= value && value <= max
+	}
 	if !shape.IsCircle {
 		for _, edge := range shape.Edges {
-			if edge.Start.X < 0 || edge.Start.Y < 0 || edge.End.X < 0 || edge.End.Y < 0 {
-				return false
-			}
-
-			if !floatEquals(edge.Start.X, canvasXMax) && edge.Start.X > canvasXMax {
-				return false
-			}
-			if !floatEquals(edge.Start.Y, canvasYMax) && edge.Start.Y > canvasYMax {
-				return false
-			}
-			if !floatEquals(edge.End.X, canvasXMax) && edge.End.X > canvasXMax {
-				return false
-			}
-			if !floatEquals(edge.End.Y, canvasYMax) && edge.End.Y > canvasYMax {
+			if !inRange(edge.Start.X, canvasXMax) || !inRange(edge.Start.Y, canvasYMax) ||
+				!inRange(edge.End.X, canvasXMax) || !inRange(edge.End.Y, canvasYMax) {
 				return false
 			}
 		}
 	} else if shape.IsCircle {
-		left := shape.Cx - shape.Radius
-		right := shape.Cx + shape.Radius
-		top := shape.Cy - shape.Radius
-		bottom := shape.Cy + shape.Radius
-		if left < 0 || top < 0 {
+		if !inRange(shape.Cx, canvasXMax) || !inRange(shape.Cy, canvasYMax) || !(shape.Radius >= 0) {
+			return false
+		}
+		// left and top: cx - r < 0 and cy - r < 0
+		if shape.Cx < shape.Radius || shape.Cy < shape.Radius {
 			return false
 		}
-		if !floatEquals(right, canvasXMax) && right > canvasXMax {
+		// right and bottom, added without rounding
+		radius := exactRat(shape.Radius)
+		if new(big.Rat).Add(exactRat(shape.Cx), radius).Cmp(exactRat(canvasXMax)) > 0 {
 			return false
 		}
-		if !floatEquals(bottom, canvasYMax) && bottom > canvasYMax {
+		if new(big.Rat).Add(exactRat(shape.Cy), radius).Cmp(exactRat(canvasYMax)) > 0 {
 			return false
 		}
 	}
@@ -352,17 +839,58 @@ func IsShapeInCanvas(shape Shape) bool {
 }
 
 /*
-	Uses md5 and hashes the shape
+	Hashes the shape with the algorithm selected by the block version
 	@param: shape
+	@param: version: block version of the network
 	@return: hash of the shape
 */
-func HashShape(shape Shape) string {
+func HashShape(shape Shape, version uint8) string {
 	sorted := shape
 	sort.Sort(Edges(sorted.Edges))
-	hasher := md5.New()
+	hasher := NewHasher(version)
 	s := fmt.Sprintf("%v", sorted)
-	hash := hasher.Sum([]byte(s))
-	return hex.EncodeToString(hash)
+	if version == BlockVersionMD5 {
+		// MD5 networks predate block versions; they append the digest of nothing to the
+		// encoded shape, so keep doing that to agree with miners on those networks.
+		return hex.EncodeToString(hasher.Sum([]byte(s)))
+	}
+	hasher.Write([]byte(s))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Returns the layer of a shape from its svg string, as returned by GetSvgString, so that viewers
+// can draw shapes on higher layers over those on lower layers.
+// @param svgString string: the svg string
+// @return uint8: the shape's layer; the base layer if the svg string does not name one
+func SvgStringLayer(svgString string) uint8 {
+	layer, err := strconv.ParseUint(svgStringAttr(svgString, "data-layer"), 10, 8)
+	if err != nil {
+		return 0
+	}
+	return uint8(layer)
+}
+
+// Returns the ID of the canvas a shape is drawn on from its svg string, as returned by GetSvgString, so
+// that viewers can draw only the shapes on the canvas they show.
+// @param svgString string: the svg string
+// @return string: the canvas's ID; empty for the network's own canvas
+func SvgStringCanvas(svgString string) string {
+	return svgStringAttr(svgString, "data-canvas")
+}
+
+// Returns the value of the named attribute in an svg string, or the empty string if it has none.
+func svgStringAttr(svgString string, name string) string {
+	attr := " " + name + "=\""
+	start := strings.Index(svgString, attr)
+	if start < 0 {
+		return ""
+	}
+	start += len(attr)
+	end := strings.Index(svgString[start:], "\"")
+	if end < 0 {
+		return ""
+	}
+	return svgString[start : start+end]
 }
 
 /*
@@ -569,6 +1097,8 @@ func handleHCase(shape *Shape, currentPoint *Point, xVal string, capital bool) e
 
 /*
 	Handles the Z/z case, closes off the shape from the origin point (not case sensitive)
+	The pen goes back to the origin point, so a path made of several closed rings (such as a shape and
+	its holes) can start each ring with a relative m
 	@param: shape: the pointer to the current shape struct, adds to the list of edges
 	@param: currentPoint: pointer to the current point (where the pen lies)
 	@param: Start: the origin point (where the pen should go back to with z)
@@ -578,6 +1108,7 @@ func handleHCase(shape *Shape, currentPoint *Point, xVal string, capital bool) e
 func handleZCase(shape *Shape, currentPoint *Point, startPoint *Point) {
 	edge := Edge{*currentPoint, *startPoint}
 	shape.Edges = append(shape.Edges, edge)
+	*currentPoint = *startPoint
 }
 
 // - calculates the amount of ink required to draw the shape, in pixels
@@ -585,24 +1116,31 @@ func handleZCase(shape *Shape, currentPoint *Point, startPoint *Point) {
 // @return ink int: amount of ink required to draw the shape
 // @return error err
 func InkUsed(shape *Shape) (ink uint32, err error) {
+	return InkUsedWithPricing(shape, InkPricing{})
+}
+
+// Calculates the ink a shape uses, as InkUsed, with the fill and border each charged at the price of their color.
+// @param shape *Shape
+// @param pricing InkPricing: the network's ink prices
+// @return uint32, error
+func InkUsedWithPricing(shape *Shape, pricing InkPricing) (ink uint32, err error) {
 	var floatInk float64 = 0
 	if !shape.FilledIn && shape.BorderColor == TRANSPARENT {
 		return 0, errors.New("Can't have transparent stroke and transparent fill")
 	}
 	if shape.FilledIn {
 		// if shape has non-transparent ink, need to find the area of it
-		// According to Ivan, if the shape has non-transparent ink, it'll be a simple closed shape
-		// with no self-intersecting lines. So we can assume this will always be the case.
-		// We need to check if the shape passed in is in fact simple and closed
+		// The shape has to be closed, but it may intersect itself or be made of several paths;
+		// its fill rule decides which of the parts they enclose are filled
+		if shape.FillRule != NONZERO && shape.FillRule != EVENODD {
+			return 0, errors.New("Unknown fill rule " + shape.FillRule.String())
+		}
 		area, err := getAreaOfShape(shape)
 		if err == nil {
-			floatInk += area
+			floatInk += area * float64(pricing.percentOf(shape.FillColor)) / 100
 		} else {
 			return 0, err
 		}
-		if !shape.IsCircle && !IsSimpleShape(shape) {
-			return 0, errors.New("Can't have non-transparent ink if shape has self-intersecting edges")
-		}
 	}
 	if shape.BorderColor != TRANSPARENT {
 		var borderLength float64 = 0
@@ -615,7 +1153,7 @@ func InkUsed(shape *Shape) (ink uint32, err error) {
 			// circumference = 2 * pi * r
 			borderLength = 2 * math.Pi * shape.Radius
 		}
-		floatInk += borderLength
+		floatInk += borderLength * float64(pricing.percentOf(shape.BorderColor)) / 100
 	}
 	ink = uint32(floatInk)
 	return ink, nil
@@ -636,40 +1174,182 @@ func IsSimpleShape(shape *Shape) bool {
 	return true
 }
 
-// Gets area of shape by going through the edges until you've reached the beginning edge again.
-// This function is assumed to be called only when calculating ink used for a non-transparent fill shape,
-// which means the shape passed in is closed.
+// Gets area of the inside of a closed shape, as decided by its fill rule.
+// The shape may intersect itself and be made of several paths, so the canvas is cut into vertical slabs
+// at the x of every point and every crossing of two edges. No edges cross or end inside a slab, so the
+// edges through it are ordered from top to bottom, and the parts of the slab between them are trapezoids
+// whose winding number is the sum of the directions of the edges above them.
+// The slabs and their areas are computed with rationals, so the area is the exact area of the shape
+// rounded once to a float64, and every miner charges the same ink for it.
 // @param shape *Shape
 // @return int
 func getAreaOfShape(shape *Shape) (float64, error) {
-	if !shape.IsCircle {
-		// https://www.mathopenref.com/coordpolygonarea.html
-		var start Edge = shape.Edges[0]
-		var area float64 = getCrossProduct(start.Start, start.End)
-		current, err := findNextEdge(shape, start)
-		if err != nil {
-			return 0, errors.New("Couldn't find area of an open shape")
+	if shape.IsCircle {
+		// area = pi * r^2
+		return math.Pi * math.Pow(shape.Radius, 2), nil
+	}
+	if !isClosedShape(shape) {
+		return 0, errors.New("Couldn't find area of an open shape")
+	}
+
+	var xs []*big.Rat
+	for i, edgeA := range shape.Edges {
+		xs = append(xs, exactRat(edgeA.Start.X), exactRat(edgeA.End.X))
+		for _, edgeB := range shape.Edges[i+1:] {
+			if contact, _ := classifyEdges(edgeA, edgeB); contact == crossContact {
+				xs = append(xs, crossingX(edgeA, edgeB))
+			}
+		}
+	}
+	sort.Slice(xs, func(a, b int) bool { return xs[a].Cmp(xs[b]) < 0 })
+
+	// an edge through a slab; y0, y1 and yMid are its y at the left, right and middle of the slab
+	type slabEdge struct {
+		y0, y1, yMid *big.Rat
+		direction    int
+	}
+	two := big.NewRat(2, 1)
+	area := new(big.Rat)
+	for i := 0; i+1 < len(xs); i++ {
+		x0, x1 := xs[i], xs[i+1]
+		if x0.Cmp(x1) == 0 {
+			continue
+		}
+		xMid := new(big.Rat).Quo(new(big.Rat).Add(x0, x1), two)
+		var through []slabEdge
+		for _, edge := range shape.Edges {
+			left, right := edge.Start, edge.End
+			direction := 1
+			if left.X > right.X {
+				left, right = right, left
+				direction = -1
+			}
+			leftX, rightX := exactRat(left.X), exactRat(right.X)
+			if leftX.Cmp(x0) > 0 || rightX.Cmp(x1) < 0 || left.X == right.X {
+				continue
+			}
+			slope := new(big.Rat).Quo(new(big.Rat).Sub(exactRat(right.Y), exactRat(left.Y)), new(big.Rat).Sub(rightX, leftX))
+			yAt := func(x *big.Rat) *big.Rat {
+				y := new(big.Rat).Mul(slope, new(big.Rat).Sub(x, leftX))
+				return y.Add(y, exactRat(left.Y))
+			}
+			through = append(through, slabEdge{yAt(x0), yAt(x1), yAt(xMid), direction})
 		}
-		// keep looping until the "current" edge is the same as the Start edge, you've found a cycle
-		for start != *current {
-			area += getCrossProduct(current.Start, current.End)
-			current, err = findNextEdge(shape, *current)
-			if err != nil {
-				return 0, errors.New("Couldn't find area of an open shape")
+		sort.Slice(through, func(a, b int) bool { return through[a].yMid.Cmp(through[b].yMid) < 0 })
+
+		width := new(big.Rat).Sub(x1, x0)
+		winding := 0
+		for j := 0; j+1 < len(through); j++ {
+			winding += through[j].direction
+			if isFilled(shape.FillRule, winding) {
+				top, bottom := through[j], through[j+1]
+				heights := new(big.Rat).Sub(bottom.y0, top.y0)
+				heights.Add(heights, bottom.y1)
+				heights.Sub(heights, top.y1)
+				trapezoid := heights.Mul(heights, width)
+				area.Add(area, trapezoid.Quo(trapezoid, two))
 			}
 		}
+	}
+	floatArea, _ := area.Float64()
+	return floatArea, nil
+}
 
-		return math.Abs(area / 2), nil
-	} else if shape.IsCircle {
-		// area = pi * r^2
-		return math.Pi * math.Pow(shape.Radius, 2), nil
+// Checks if the shape's edges form closed paths, which is when every point starts as many edges as it ends.
+// @param shape *Shape
+// @return bool
+func isClosedShape(shape *Shape) bool {
+	if len(shape.Edges) == 0 {
+		return false
+	}
+	degrees := make(map[Point]int)
+	for _, edge := range shape.Edges {
+		degrees[edge.Start]++
+		degrees[edge.End]--
 	}
-	return 0, nil
+	for _, degree := range degrees {
+		if degree != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds the square of the distance from a point to the closest point of an edge, exactly.
+// @param point Point
+// @param edge Edge
+// @return *big.Rat
+func squaredDistanceToEdge(point Point, edge Edge) *big.Rat {
+	dx := new(big.Rat).Sub(exactRat(edge.End.X), exactRat(edge.Start.X))
+	dy := new(big.Rat).Sub(exactRat(edge.End.Y), exactRat(edge.Start.Y))
+	px := new(big.Rat).Sub(exactRat(point.X), exactRat(edge.Start.X))
+	py := new(big.Rat).Sub(exactRat(point.Y), exactRat(edge.Start.Y))
+	if lengthSquared := ratDot(dx, dx, dy, dy); lengthSquared.Sign() > 0 {
+		// how far along the edge the point's projection onto it is, kept on the edge
+		t := new(big.Rat).Quo(ratDot(px, dx, py, dy), lengthSquared)
+		if t.Sign() < 0 {
+			t.SetInt64(0)
+		} else if t.Cmp(big.NewRat(1, 1)) > 0 {
+			t.SetInt64(1)
+		}
+		px.Sub(px, new(big.Rat).Mul(t, dx))
+		py.Sub(py, new(big.Rat).Mul(t, dy))
+	}
+	return ratDot(px, px, py, py)
+}
+
+// Finds the square of the distance from a point to the line through an edge, exactly; for an edge
+// of no length, the distance to its point.
+// @param point Point
+// @param edge Edge
+// @return *big.Rat
+func squaredDistanceToLine(point Point, edge Edge) *big.Rat {
+	dx := new(big.Rat).Sub(exactRat(edge.End.X), exactRat(edge.Start.X))
+	dy := new(big.Rat).Sub(exactRat(edge.End.Y), exactRat(edge.Start.Y))
+	px := new(big.Rat).Sub(exactRat(point.X), exactRat(edge.Start.X))
+	py := new(big.Rat).Sub(exactRat(point.Y), exactRat(edge.Start.Y))
+	lengthSquared := ratDot(dx, dx, dy, dy)
+	if lengthSquared.Sign() == 0 {
+		return ratDot(px, px, py, py)
+	}
+	// |d x p| / |d| is the distance to the line
+	cross := new(big.Rat).Sub(new(big.Rat).Mul(dx, py), new(big.Rat).Mul(dy, px))
+	return new(big.Rat).Quo(cross.Mul(cross, cross), lengthSquared)
+}
+
+// Finds the x at which two edges that cross each other cross, exactly.
+// @param A Edge
+// @param B Edge
+// @return *big.Rat
+func crossingX(A Edge, B Edge) *big.Rat {
+	directionAX := new(big.Rat).Sub(exactRat(A.End.X), exactRat(A.Start.X))
+	directionAY := new(big.Rat).Sub(exactRat(A.End.Y), exactRat(A.Start.Y))
+	directionBX := new(big.Rat).Sub(exactRat(B.End.X), exactRat(B.Start.X))
+	directionBY := new(big.Rat).Sub(exactRat(B.End.Y), exactRat(B.Start.Y))
+	startsApartX := new(big.Rat).Sub(exactRat(B.Start.X), exactRat(A.Start.X))
+	startsApartY := new(big.Rat).Sub(exactRat(B.Start.Y), exactRat(A.Start.Y))
+	// t = (startsApart x directionB) / (directionA x directionB); the edges cross, so they aren't parallel
+	numerator := new(big.Rat).Sub(new(big.Rat).Mul(startsApartX, directionBY), new(big.Rat).Mul(startsApartY, directionBX))
+	denominator := new(big.Rat).Sub(new(big.Rat).Mul(directionAX, directionBY), new(big.Rat).Mul(directionAY, directionBX))
+	x := new(big.Rat).Quo(numerator, denominator)
+	x.Mul(x, directionAX)
+	return x.Add(x, exactRat(A.Start.X))
+}
+
+// Checks if the points a path winds around the passed number of times are inside it under the fill rule.
+// @param rule FillRule
+// @param winding int
+// @return bool
+func isFilled(rule FillRule, winding int) bool {
+	if rule == EVENODD {
+		return winding%2 != 0
+	}
+	return winding != 0
 }
 
 // @param A Shape
 // @param B Shape
-// @param canvasSettings CanvasSettings: Used to pass in the settings to the call to pointInShape
+// @param canvasSettings CanvasSettings: The settings of the canvas the shapes are on
 // @return bool
 func ShapesIntersect(A Shape, B Shape, canvasSettings CanvasSettings) bool {
 	if !A.IsCircle && !B.IsCircle {
@@ -683,46 +1363,45 @@ func ShapesIntersect(A Shape, B Shape, canvasSettings CanvasSettings) bool {
 		}
 
 		// The following cases test if a shape fully envelopes another shape.
+		// As no edges cross, each of a shape's paths lies in a single part of the other shape, but the
+		// shapes may be made of several paths, and the other shape may have holes, so every point is tested.
 		if A.FilledIn || B.FilledIn {
 			// Test if B is a closed shape
-			if _, err := getAreaOfShape(&B); err == nil {
-				//2. If not, then choose any one point of the first polygon and test whether it is fully inside the second.
-				pointA := A.Edges[0].Start
-				if pointInShape(pointA, B, canvasSettings) { // this only matters if it's filled in.
-					return true
+			if isClosedShape(&B) {
+				//2. If not, then test whether any point of the first polygon is inside the second.
+				for _, edgeA := range A.Edges {
+					if pointInShape(edgeA.Start, B) { // this only matters if it's filled in.
+						return true
+					}
 				}
 			}
 
-			if _, err := getAreaOfShape(&A); err == nil {
-				//3. If not, then choose any one point of the second polygon and test whether it is fully inside the first.
-				pointB := B.Edges[0].Start
-				if pointInShape(pointB, A, canvasSettings) {
-					return true
+			if isClosedShape(&A) {
+				//3. If not, then test whether any point of the second polygon is inside the first.
+				for _, edgeB := range B.Edges {
+					if pointInShape(edgeB.Start, A) {
+						return true
+					}
 				}
 			}
 		}
 		//4. If not, then you can conclude that the two polygons are completely outside each other.
 		return false
 	} else if A.IsCircle && B.IsCircle {
-/*		//https://www.geeksforgeeks.org/check-two-given-circles-touch-intersect/ - didn't work for encompassing circle
-		distance := math.Sqrt(math.Pow(A.Cx - B.Cx, 2) + math.Pow(A.Cy - B.Cy, 2))
-		if floatEquals(A.Radius + B.Radius, distance) || distance < A.Radius + B.Radius {
-			return true
-		}*/
-		distanceSquared := math.Pow(A.Cx - B.Cx, 2) + math.Pow(A.Cy - B.Cy, 2)
-		if math.Pow(A.Radius - B.Radius, 2) <= distanceSquared && distanceSquared <= math.Pow(A.Radius + B.Radius, 2) {
+		// The outlines touch iff |rA - rB| <= distance <= rA + rB; the squares are compared exactly
+		distanceSquared := squaredDistance(Point{A.Cx, A.Cy}, Point{B.Cx, B.Cy})
+		radiiDifference := new(big.Rat).Sub(exactRat(A.Radius), exactRat(B.Radius))
+		radiiSum := new(big.Rat).Add(exactRat(A.Radius), exactRat(B.Radius))
+		radiiDifference.Mul(radiiDifference, radiiDifference)
+		radiiSum.Mul(radiiSum, radiiSum)
+		if radiiDifference.Cmp(distanceSquared) <= 0 && distanceSquared.Cmp(radiiSum) <= 0 {
 			return true
 		}
 		if A.FilledIn || B.FilledIn {
-			// if no edges of circle intersect each other, then check if a circle completely overlaps another
+			// if no edges of circle intersect each other, then check if a circle completely overlaps another,
+			// which is when the distance is less than |rA - rB|
 			// this only matters if A and B are both filled in
-			radiiEdge := Edge{Start:Point{A.Cx, A.Cy}, End:Point{B.Cx, B.Cy}}
-			length := getLengthOfEdge(radiiEdge)
-			if A.Radius > B.Radius {
-				return A.Radius > length + B.Radius
-			} else {
-				return B.Radius > length + A.Radius
-			}
+			return distanceSquared.Cmp(radiiDifference) < 0
 		}
 	} else {
 		// one of A,B is a circle and one of A,B is a path-shape
@@ -736,8 +1415,9 @@ func ShapesIntersect(A Shape, B Shape, canvasSettings CanvasSettings) bool {
 			path = A
 		}
 		// https://stackoverflow.com/a/402019/5759077
-		// Either the circle's centre lies inside the rectangle
-		if pointInShape(Point{circle.Cx, circle.Cy}, path, canvasSettings) {
+		// Either the circle's centre lies inside the rectangle; only closed paths have an inside, which
+		// leaves out their holes
+		if isClosedShape(&path) && pointInShape(Point{circle.Cx, circle.Cy}, path) {
 			// in the circle
 			if circle.FilledIn || path.FilledIn {
 				return true
@@ -745,34 +1425,20 @@ func ShapesIntersect(A Shape, B Shape, canvasSettings CanvasSettings) bool {
 			return !checkSurroundsCircleShape(circle, path)
 		}
 
-		var distance float64
-		var A float64
-		var B float64
-		var C float64
+		// distances are compared to the radius by their squares, exactly
+		center := Point{circle.Cx, circle.Cy}
+		radius := exactRat(circle.Radius)
+		radiusSquared := new(big.Rat).Mul(radius, radius)
 		// or one of the edges of the rectangle has a point in the circle
 		for _, edge := range path.Edges {
-			// get equation of the line
-			// y = mx + b
-			if edge.End.X - edge.Start.X != 0 {
-				slope := (edge.End.Y - edge.Start.Y) / (edge.End.X - edge.Start.X) // m
-				intercept := edge.End.Y - slope * edge.End.X // b (b = y - mx)
-				// Line has form Ax + By + C = 0, y = mx + b, y - mx - b = 0, so A = -m, B = 1, C = -b
-				A = -1 * slope
-				B = 1
-				C = -1 * intercept
-			} else {
-				// vertical line..
-				A = 0
-				B = 1
-				C = -1 * circle.Cy
+			// an edge of a hole can pass through the circle with both its ends outside of it
+			if (circle.FilledIn || path.FilledIn) && squaredDistanceToEdge(center, edge).Cmp(radiusSquared) < 0 {
+				return true
 			}
-			// distance, with point (m,n)
-			distance = math.Abs(A * circle.Cx + B * circle.Cy + C) / math.Sqrt(math.Pow(A, 2) + math.Pow(B, 2))
-			if distance < circle.Radius {
+			if squaredDistanceToLine(center, edge).Cmp(radiusSquared) < 0 {
 				// now check if any of the two vertices of the shape is closer than the radius
-				verticeEdge1 := Edge{Start:Point{circle.Cx, circle.Cy}, End:Point{edge.Start.X, edge.Start.Y}}
-				verticeEdge2 := Edge{Start:Point{circle.Cx, circle.Cy}, End:Point{edge.Start.X, edge.Start.Y}}
-				if getLengthOfEdge(verticeEdge1) <= circle.Radius || getLengthOfEdge(verticeEdge2) <= circle.Radius {
+				if squaredDistance(center, edge.Start).Cmp(radiusSquared) <= 0 ||
+					squaredDistance(center, edge.End).Cmp(radiusSquared) <= 0 {
 					if circle.FilledIn || path.FilledIn {
 						return true
 					}
@@ -785,17 +1451,18 @@ func ShapesIntersect(A Shape, B Shape, canvasSettings CanvasSettings) bool {
 }
 
 func checkSurroundsCircleShape(circle Shape, other Shape) bool {
+	// lengths are compared to the radius by their squares, exactly
+	center := Point{circle.Cx, circle.Cy}
+	radius := exactRat(circle.Radius)
+	radiusSquared := new(big.Rat).Mul(radius, radius)
 	// check if every vertex is inside the circle
 	inCircle := true
-	var length float64
 	for _, edge := range other.Edges {
-		length = getLengthOfEdge(Edge{Start:edge.Start, End:Point{circle.Cx, circle.Cy}})
-		if length > circle.Radius {
+		if squaredDistance(edge.Start, center).Cmp(radiusSquared) > 0 {
 			inCircle = false
 			break
 		}
-		length = getLengthOfEdge(Edge{Start:edge.End, End:Point{X: circle.Cx, Y:circle.Cy}})
-		if length > circle.Radius {
+		if squaredDistance(edge.End, center).Cmp(radiusSquared) > 0 {
 			inCircle = false
 			break
 		}
@@ -811,13 +1478,11 @@ func checkSurroundsCircleShape(circle Shape, other Shape) bool {
 	inShape := true
 	// check if radius is shorter than the length of the edge from the center of circle to each vertex
 	for _, edge := range other.Edges {
-		length = getLengthOfEdge(Edge{Start:edge.Start, End:Point{circle.Cx, circle.Cy}})
-		if length < circle.Radius {
+		if squaredDistance(edge.Start, center).Cmp(radiusSquared) < 0 {
 			inCircle = false
 			break
 		}
-		length = getLengthOfEdge(Edge{Start:edge.End, End:Point{X: circle.Cx, Y:circle.Cy}})
-		if length < circle.Radius {
+		if squaredDistance(edge.End, center).Cmp(radiusSquared) < 0 {
 			inCircle = false
 			break
 		}
@@ -828,104 +1493,113 @@ func checkSurroundsCircleShape(circle Shape, other Shape) bool {
 	return false
 }
 
-// Detects if two edges intersect
+// Detects if two edges intersect. The test is exact: the coordinates are taken as the rationals they
+// represent and compared without rounding, so every miner reaches the same verdict for the same edges.
 // @param A Edge
 // @param B Edge
 // @bool countTipToTipIntersect bool: False during the check if a shape is simple. Edges that are connected
 // to each other in a shape technically "intersect", so we don't want to return true in these instances.
 // @return bool
 func EdgesIntersect(A Edge, B Edge, countTipToTipIntersect bool) bool {
-	// https://martin-thoma.com/how-to-check-if-two-line-segments-intersect/
+	contact, point := classifyEdges(A, B)
+	switch contact {
+	case crossContact, overlapContact:
+		return true
+	case pointContact:
+		return countTipToTipIntersect || !isEndPointOf(point, A) || !isEndPointOf(point, B)
+	}
+	return false
+}
+
+// How two edges touch each other.
+type edgeContact int
+
+const (
+	// The edges have no point in common.
+	noContact edgeContact = iota
+	// The edges have a single point in common, which is an end point of at least one of them.
+	pointContact
+	// The edges cross at a single point inside both of them.
+	crossContact
+	// The edges lie on the same line and share a part of it of non-zero length.
+	overlapContact
+)
 
-	// 1: Check if each edge intersect
+// Finds how two edges touch. Private helper function for EdgesIntersect.
+// https://martin-thoma.com/how-to-check-if-two-line-segments-intersect/
+// @param A Edge
+// @param B Edge
+// @return edgeContact
+// @return Point: the point the edges have in common iff the contact is pointContact
+func classifyEdges(A Edge, B Edge) (edgeContact, Point) {
+	// 1: Edges whose bounding boxes don't intersect can't intersect
 	var boxA Box = buildBoundingBox(A)
 	var boxB Box = buildBoundingBox(B)
-
 	if !boxesIntersect(boxA, boxB) {
-		return false
+		return noContact, Point{}
 	}
 
-	// 2: Check if edge A intersects with edge segment B
-	// 2a: Check if the Start or End point of B is on line A - this is for parallel lines
-	// If cross product between two points is 0, it means the two points are on the same line through origin
-	// meaning it is necessary to translate the edge to the origin, and the points of B accordingly
-	var edgeA Edge = Edge{Start: Point{X: 0, Y: 0},
-		End: Point{X: A.End.X - A.Start.X, Y: A.End.Y - A.Start.Y}}
-	var pointB1 Point = Point{X: B.Start.X - A.Start.X, Y: B.Start.Y - A.Start.Y}
-	var pointB2 Point = Point{X: B.End.X - A.Start.X, Y: B.End.Y - A.Start.Y}
-	var edgeB Edge = Edge{Start: pointB1, End: pointB2}
-	if pointsAreOnSameLine(edgeA.End, pointB1) || pointsAreOnSameLine(edgeA.End, pointB2) {
-		if !countTipToTipIntersect {
-			// if the endpoints are the only ones touching the edge, don't return true
-			if !onlyIntersectsAtEndPoint(edgeA, edgeB) {
-				return true
-			}
-		} else {
-			return true
+	// 2: Find on which side of each edge the end points of the other lie
+	o1 := orientation(A.Start, A.End, B.Start)
+	o2 := orientation(A.Start, A.End, B.End)
+	o3 := orientation(B.Start, B.End, A.Start)
+	o4 := orientation(B.Start, B.End, A.End)
+
+	// 2a: All four points on one line; the edges share the intersection of their bounding boxes
+	if o1 == 0 && o2 == 0 && o3 == 0 && o4 == 0 {
+		minX, maxX := math.Max(boxA.MinX, boxB.MinX), math.Min(boxA.MaxX, boxB.MaxX)
+		minY, maxY := math.Max(boxA.MinY, boxB.MinY), math.Min(boxA.MaxY, boxB.MaxY)
+		if minX == maxX && minY == maxY {
+			return pointContact, Point{X: minX, Y: minY}
 		}
+		return overlapContact, Point{}
 	}
-	// 2b: Check if the cross product of the Start and End points of B with line A are of different signs
-	// if they are, the lines intersect
-	// https://stackoverflow.com/questions/7069420/check-if-two-line-segments-are-colliding-only-check-if-they-are-intersecting-n
-	pointB1 = B.Start
-	pointB2 = B.End
-	//A.X * B.Y - B.X * A.Y
-	crossProduct1 := getCrossProduct(Point{X: A.End.X - A.Start.X, Y: pointB1.X - A.End.X},
-		Point{X: A.End.Y - A.Start.Y, Y: pointB1.Y - A.End.Y})
-	crossProduct2 := getCrossProduct(Point{X: A.End.X - A.Start.X, Y: pointB2.X - A.End.X},
-		Point{X: A.End.Y - A.Start.Y, Y: pointB2.Y - A.End.Y})
-	// if intersect, the signs of these cross products will be different
-	return crossProduct1 != 0 && crossProduct2 != 0 &&
-		(crossProduct1 < 0 || crossProduct2 < 0) && !(crossProduct1 < 0 && crossProduct2 < 0)
-}
-
-// Checks if the two lines (B represented by its endpoints)
-// only intersect at one of its tips. Private helper function for EdgesIntersect.
+
+	// 2b: The end points of each edge are strictly on either side of the other edge
+	if o1*o2 < 0 && o3*o4 < 0 {
+		return crossContact, Point{}
+	}
+
+	// 2c: An end point of one edge lies on the other edge. The edges aren't on one line, so they
+	// have at most one point in common.
+	switch {
+	case o1 == 0 && pointInBox(B.Start, boxA):
+		return pointContact, B.Start
+	case o2 == 0 && pointInBox(B.End, boxA):
+		return pointContact, B.End
+	case o3 == 0 && pointInBox(A.Start, boxB):
+		return pointContact, A.Start
+	case o4 == 0 && pointInBox(A.End, boxB):
+		return pointContact, A.End
+	}
+	return noContact, Point{}
+}
+
+// Checks if the two edges only intersect at a point that is an end point of both of them,
+// as two edges that follow each other in a shape do. Private helper function for EdgesIntersect.
 // @param edgeA Edge
 // @param edgeB Edge
 // @return bool
 func onlyIntersectsAtEndPoint(edgeA Edge, edgeB Edge) bool {
-	// to account for corner cases of horizontal/vertical lines,
-	// have to check if line is more "vertical" or "horizontal"
-	var pointB1 Point = edgeB.Start
-	var pointB2 Point = edgeB.End
-	slopeEdge := math.Abs((edgeA.End.Y - edgeA.Start.Y) / (edgeA.End.X - edgeA.Start.X))
-	slopeB := math.Abs((pointB2.Y - pointB1.Y) / (pointB2.X - pointB1.X))
-	parallel := floatEquals(slopeEdge, slopeB)
-	if pointB1 == edgeA.Start || pointB1 == edgeA.End {
-		if parallel {
-			// pointB2 has to be going the opposite direction from edgeA.End
-			if pointB1 == edgeA.Start {
-				slopeB = (pointB2.Y - pointB1.Y) / (pointB2.X - pointB1.X)
-				slopeEdge = (edgeA.End.Y - edgeA.Start.Y) / (edgeA.End.X - edgeA.Start.X)
-				return slopeB == -1*slopeEdge
-			} else {
-				// pointB2 has to be going the opposite direction from edgeA.Start
-				slopeB = (pointB2.Y - pointB1.Y) / (pointB2.X - pointB1.X)
-				slopeEdge = (edgeA.Start.Y - edgeA.End.Y) / (edgeA.Start.X - edgeA.End.X)
-				return slopeB == -1*slopeEdge
-			}
-		} else {
-			return true
-		}
-	} else if pointB2 == edgeA.Start || pointB2 == edgeA.End {
-		if parallel {
-			// pointB1 has to be going the opposite direction from edgeA.End
-			if pointB2 == edgeA.Start {
-				slopeB = (pointB1.Y - pointB2.Y) / (pointB1.X - pointB2.X)
-				slopeEdge = (edgeA.End.Y - edgeA.Start.Y) / (edgeA.End.X - edgeA.Start.X)
-				return slopeB == -1*slopeEdge
-			} else {
-				// pointB1 has to be going the opposite direction from edgeA.Start
-				slopeB = (pointB1.Y - pointB2.Y) / (pointB1.X - pointB2.X)
-				slopeEdge = (edgeA.Start.Y - edgeA.End.Y) / (edgeA.Start.X - edgeA.End.X)
-				return slopeB == -1*slopeEdge
-			}
-		} else {
-			return true
-		}
-	}
-	return false
+	contact, point := classifyEdges(edgeA, edgeB)
+	return contact == pointContact && isEndPointOf(point, edgeA) && isEndPointOf(point, edgeB)
+}
+
+// Checks if the point is the Start or End of the edge.
+// @param point Point
+// @param edge Edge
+// @return bool
+func isEndPointOf(point Point, edge Edge) bool {
+	return point == edge.Start || point == edge.End
+}
+
+// Checks if the point lies in the box, including on its border.
+// @param point Point
+// @param box Box
+// @return bool
+func pointInBox(point Point, box Box) bool {
+	return box.MinX <= point.X && point.X <= box.MaxX &&
+		box.MinY <= point.Y && point.Y <= box.MaxY
 }
 
 // Builds a bounding box for an edge. Private helper method for EdgesIntersect
@@ -950,6 +1624,54 @@ func buildBoundingBox(edge Edge) Box {
 	return boxA
 }
 
+// Builds the smallest box that holds a shape: its edges, or its circle.
+// @param shape Shape
+// @return Box
+func ShapeBounds(shape Shape) Box {
+	if shape.IsCircle {
+		return Box{MinX: shape.Cx - shape.Radius, MaxX: shape.Cx + shape.Radius,
+			MinY: shape.Cy - shape.Radius, MaxY: shape.Cy + shape.Radius}
+	}
+	var bounds Box
+	for i, edge := range shape.Edges {
+		box := buildBoundingBox(edge)
+		if i == 0 {
+			bounds = box
+			continue
+		}
+		bounds.MinX = math.Min(bounds.MinX, box.MinX)
+		bounds.MaxX = math.Max(bounds.MaxX, box.MaxX)
+		bounds.MinY = math.Min(bounds.MinY, box.MinY)
+		bounds.MaxY = math.Max(bounds.MaxY, box.MaxY)
+	}
+	return bounds
+}
+
+// Checks if a shape touches a region of the canvas, including if it lies entirely inside it.
+// @param shape Shape
+// @param region Box
+// @param canvasSettings CanvasSettings
+// @return bool
+func ShapeInRegion(shape Shape, region Box, canvasSettings CanvasSettings) bool {
+	corners := []Point{{region.MinX, region.MinY}, {region.MaxX, region.MinY}, {region.MaxX, region.MaxY}, {region.MinX, region.MaxY}}
+	regionShape := Shape{FilledIn: true}
+	for i := range corners {
+		regionShape.Edges = append(regionShape.Edges, Edge{Start: corners[i], End: corners[(i+1)%len(corners)]})
+	}
+	return ShapesIntersect(shape, regionShape, canvasSettings)
+}
+
+// Checks if two regions of the canvas share any area; regions that only share a border do not.
+// @param A Box
+// @param B Box
+// @return bool
+func RegionsOverlap(A Box, B Box) bool {
+	return A.MaxX > B.MinX &&
+		A.MinX < B.MaxX &&
+		A.MaxY > B.MinY &&
+		A.MinY < B.MaxY
+}
+
 // Checks if two boxes intersect. Private helper method for EdgesIntersect
 // @param A Box
 // @param B Box
@@ -962,41 +1684,74 @@ func boxesIntersect(A Box, B Box) bool {
 		A.MinY <= B.MaxY
 }
 
-// Checks if given point is in the given shape. Helper method for ShapesIntersect.
-// Uses ray method - create a ray (horizontal edge) from point to edge of canvas,
-// if the ray passes through an odd number of edges then the point is in the shape
+// Checks if given point is in the given shape, counting points on its edges as in it. Helper method for
+// ShapesIntersect. Uses ray method - cast a ray (horizontal edge) from point to the right, and add up the
+// directions of the edges it crosses to find how many times the shape winds around the point; the shape's
+// fill rule then decides if the point is inside. An edge is crossed if one of its end points is above the
+// ray and the other is not, so rays that pass through a vertex are counted once.
 // @param point Point
 // @param shape Shape
-// @param settings CanvasSettings: Get the max canvas limit for X
-func pointInShape(point Point, shape Shape, settings CanvasSettings) bool {
+func pointInShape(point Point, shape Shape) bool {
 	// https://www.geeksforgeeks.org/how-to-check-if-a-given-point-lies-inside-a-polygon/
-
-	var extendedX = float64(settings.CanvasXMax)
-	var edge = Edge{Start: point, End: Point{X: extendedX, Y: point.Y}}
-	// if this edge passes through an odd number of edges, the point is in shape
-	intersections := 0
+	winding := 0
 	for _, e := range shape.Edges {
-		if EdgesIntersect(edge, e, true) {
-			intersections++
+		side := orientation(e.Start, e.End, point)
+		if side == 0 && pointInBox(point, buildBoundingBox(e)) {
+			return true
+		}
+		// the edge crosses the ray if the point is to the left of the edge when going up the edge,
+		// or to the right of it when going down
+		if e.Start.Y <= point.Y && e.End.Y > point.Y && side > 0 {
+			winding++
+		} else if e.End.Y <= point.Y && e.Start.Y > point.Y && side < 0 {
+			winding--
 		}
 	}
-	return intersections%2 == 1
+	return isFilled(shape.FillRule, winding)
 }
 
-// Checks if the two points are on the origin. Private helper method for EdgesIntersect.
-// @param A Point
-// @param B Point
-// @return bool
-func pointsAreOnSameLine(A Point, B Point) bool {
-	return getCrossProduct(A, B) < EPSILON
+// Finds on which side of the line through p and q the point r lies, exactly: the sign of the
+// cross product of q - p and r - p. Every float64 is a rational, so the cross product is computed
+// without rounding, and every miner gets the same sign.
+// @param p Point
+// @param q Point
+// @param r Point
+// @return int: 1 if r is to the left of the line going from p to q, -1 if it is to the right,
+// 0 if it is on the line
+func orientation(p Point, q Point, r Point) int {
+	dx1 := new(big.Rat).Sub(exactRat(q.X), exactRat(p.X))
+	dy1 := new(big.Rat).Sub(exactRat(q.Y), exactRat(p.Y))
+	dx2 := new(big.Rat).Sub(exactRat(r.X), exactRat(p.X))
+	dy2 := new(big.Rat).Sub(exactRat(r.Y), exactRat(p.Y))
+	left := new(big.Rat).Mul(dx1, dy2)
+	right := new(big.Rat).Mul(dy1, dx2)
+	return left.Cmp(right)
+}
+
+// Takes a float64 as the rational it represents. Sums and products of these are exact, so
+// geometry done on them gives every miner the same answer.
+// @param f float64: a finite number
+// @return *big.Rat
+func exactRat(f float64) *big.Rat {
+	return new(big.Rat).SetFloat64(f)
 }
 
-// Gets cross product of two points
+// Gets a*b + c*d, exactly.
+// @param a, b, c, d *big.Rat
+// @return *big.Rat
+func ratDot(a *big.Rat, b *big.Rat, c *big.Rat, d *big.Rat) *big.Rat {
+	product := new(big.Rat).Mul(a, b)
+	return product.Add(product, new(big.Rat).Mul(c, d))
+}
+
+// Gets the square of the distance between two points, exactly.
 // @param A Point
 // @param B Point
-// @return int
-func getCrossProduct(A Point, B Point) float64 {
-	return A.X*B.Y - B.X*A.Y
+// @return *big.Rat
+func squaredDistance(A Point, B Point) *big.Rat {
+	dx := new(big.Rat).Sub(exactRat(A.X), exactRat(B.X))
+	dy := new(big.Rat).Sub(exactRat(A.Y), exactRat(B.Y))
+	return ratDot(dx, dx, dy, dy)
 }
 
 // Gets length of an edge
@@ -1011,22 +1766,6 @@ func getLengthOfEdge(edge Edge) float64 {
 	return c
 }
 
-// Finds the next edge of the shape given current edge and the list of edges in shape
-// @param shape *Shape
-// @param edge Edge
-// @return Edge
-func findNextEdge(shape *Shape, edge Edge) (*Edge, error) {
-	var ret *Edge
-	for _, e := range shape.Edges {
-		if e.Start.X == edge.End.X &&
-			e.Start.Y == edge.End.Y {
-			ret = &e
-			return ret, nil
-		}
-	}
-	return nil, errors.New("Couldn't find next edge") // todo: find a better error?
-}
-
 // Checks for float equality by checking if the difference between the two floats is small
 // @param a float64
 // @param b float64
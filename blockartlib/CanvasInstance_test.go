@@ -1,6 +1,9 @@
 package blockartlib
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"testing"
 	"fmt"
 )
@@ -15,7 +18,7 @@ func TestSvgToShape(t *testing.T) {
 	// TEST happy path case
 	setUpCanvas(100, 100)
 	svgPath := "M 0 0 H 30 L 10 20 Z"
-	shape, err := svgToShape(svgPath)
+	shape, err := svgToShape(svgPath, "")
 	edges := []Edge{}
 	edges = append(edges, Edge{Start:Point{X:0, Y:0}, End:Point{X:30, Y:0}})
 	edges = append(edges, Edge{Start:Point{X:30, Y:0}, End:Point{X:10, Y:20}})
@@ -49,7 +52,7 @@ func TestSvgToShape(t *testing.T) {
 								"H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 " +
 									"H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 " +
 										"H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 H 1 V 1 Z"
-	shape, err = svgToShape(svgPath)
+	shape, err = svgToShape(svgPath, "")
 	if err != nil {
 		switch err.(type) {
 		case ShapeSvgStringTooLongError:
@@ -62,7 +65,7 @@ func TestSvgToShape(t *testing.T) {
 	}
 
 	svgPath = "M 0 0 H 30 M 0 10 L 30 40"
-	shape, err = svgToShape(svgPath)
+	shape, err = svgToShape(svgPath, "")
 	edges = []Edge{}
 	edges = append(edges, Edge{Start:Point{X:0, Y:0}, End:Point{X:30, Y:0}})
 	edges = append(edges, Edge{Start:Point{X:0, Y:10}, End:Point{X:30, Y:40}})
@@ -87,7 +90,7 @@ func TestSvgToShape(t *testing.T) {
 }
 
 func TestSvgToCircleShape(t *testing.T) {
-	shape, err := svgToCircleShape("1,2,5")
+	shape, err := svgToCircleShape("1,2,5", "")
 	if err != nil {
 		fmt.Errorf("%v\n", err)
 		return
@@ -126,6 +129,11 @@ func TestIsShapeInCanvas(t *testing.T) {
 	if IsShapeInCanvas(shape) {
 		t.Errorf("edge {0,-1}->{1,2} should not be within canvas limits, is \n")
 	}
+	// the bounds are exact, with no tolerance
+	shape = Shape{Edges: []Edge{{Start: Point{0, 0}, End: Point{100.0000001, 0}}}}
+	if IsShapeInCanvas(shape) {
+		t.Errorf("edge {0,0}->{100.0000001,0} should not be within canvas limits, is \n")
+	}
 	//circle cases
 	// fits in canvas
 	circle := Shape{IsCircle:true, Cx:5, Cy:5, Radius:2}
@@ -205,12 +213,32 @@ func TestInkUsed(t *testing.T) {
 	if ink != 68 {
 		t.Errorf("Expected 68 units of ink, used %d \n", ink)
 	}
-	// Case 5: Self-intersecting shape, fill
+	// Case 5: Self-intersecting shape, fill, whose diagonals leave it open
 	// Expect error
 	shape.FilledIn = true
 	ink, err = InkUsed(&shape)
 	if err == nil {
-		t.Errorf("Expected error when filledIn = true on self-intersecting shape \n")
+		t.Errorf("Expected error when filledIn = true on open self-intersecting shape \n")
+	}
+	// Case 6: Closed self-intersecting shape, fill, with border
+	// Expect the area of its two triangles plus its border
+	shape.Edges = []Edge{Edge{Start:Point{0,0}, End:Point{10,10}},
+						Edge{Start:Point{10,10}, End:Point{10,0}},
+						Edge{Start:Point{10,0}, End:Point{0,10}},
+						Edge{Start:Point{0,10}, End:Point{0,0}}}
+	ink, err = InkUsed(&shape)
+	if err != nil {
+		t.Errorf("Received error %v \n", err)
+	}
+	if ink != 98 {
+		t.Errorf("Expected 98 units of ink, used %d \n", ink)
+	}
+	// Case 7: Unknown fill rule
+	// Expect error
+	shape.FillRule = EVENODD + 1
+	ink, err = InkUsed(&shape)
+	if err == nil {
+		t.Errorf("Expected error for unknown fill rule, received %d ink used\n", ink)
 	}
 
 	// Circles - for these values just check the approximate value
@@ -321,6 +349,115 @@ func TestGetAreaOfShape(t *testing.T) {
 	}
 }
 
+// Returns the edges of a square path going clockwise, or anti-clockwise if reversed is true.
+func squareEdges(minX float64, minY float64, size float64, reversed bool) []Edge {
+	corners := []Point{{minX, minY}, {minX + size, minY}, {minX + size, minY + size}, {minX, minY + size}}
+	var edges []Edge
+	for i := range corners {
+		edge := Edge{Start:corners[i], End:corners[(i+1)%len(corners)]}
+		if reversed {
+			edge = Edge{Start:edge.End, End:edge.Start}
+		}
+		edges = append(edges, edge)
+	}
+	return edges
+}
+
+func TestGetAreaOfShapeFillRule(t *testing.T) {
+	cases := []struct {
+		name    string
+		edges   []Edge
+		nonzero float64
+		evenodd float64
+	}{
+		// Case 1: Square with a hole going the same way; only evenodd leaves the hole empty
+		{"hole same way", append(squareEdges(0, 0, 10, false), squareEdges(2, 2, 6, false)...), 100, 64},
+		// Case 2: Square with a hole going the other way; both leave the hole empty
+		{"hole other way", append(squareEdges(0, 0, 10, false), squareEdges(2, 2, 6, true)...), 64, 64},
+		// Case 3: Two overlapping squares; evenodd leaves their overlap empty
+		{"overlapping", append(squareEdges(0, 0, 10, false), squareEdges(5, 0, 10, false)...), 150, 100},
+		// Case 4: Bowtie, a path crossing itself
+		{"bowtie", []Edge{{Point{0, 0}, Point{10, 10}}, {Point{10, 10}, Point{10, 0}},
+			{Point{10, 0}, Point{0, 10}}, {Point{0, 10}, Point{0, 0}}}, 50, 50},
+	}
+	for _, c := range cases {
+		for rule, expected := range map[FillRule]float64{NONZERO: c.nonzero, EVENODD: c.evenodd} {
+			shape := Shape{Edges: c.edges, FilledIn: true, FillRule: rule}
+			area, err := getAreaOfShape(&shape)
+			if err != nil {
+				t.Errorf("%s, %v: received error %v\n", c.name, rule, err)
+			}
+			if !floatEquals(area, expected) {
+				t.Errorf("%s, %v: expected area %f, received %f\n", c.name, rule, expected, area)
+			}
+		}
+	}
+}
+
+func TestShapesIntersectHole(t *testing.T) {
+	setUpCanvas(100, 100)
+	var settings = CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}
+	var frame = Shape{Edges: append(squareEdges(0, 0, 30, false), squareEdges(10, 10, 10, false)...), FilledIn: true}
+	var inHole = Shape{Edges: squareEdges(12, 12, 5, false), FilledIn: true}
+	// Case 1: Nonzero fills the hole
+	// Expect true
+	if !ShapesIntersect(frame, inHole, settings) {
+		t.Errorf("Expected shape in the hole of a nonzero shape to intersect it\n")
+	}
+	// Case 2: Evenodd leaves the hole empty
+	// Expect false
+	frame.FillRule = EVENODD
+	if ShapesIntersect(frame, inHole, settings) || ShapesIntersect(inHole, frame, settings) {
+		t.Errorf("Expected shape in the hole of an evenodd shape to not intersect it\n")
+	}
+	// Case 3: Shape whose second path is in the hole
+	// Expect true
+	var island = Shape{Edges: append(squareEdges(50, 50, 5, false), squareEdges(14, 14, 2, false)...), FilledIn: true}
+	var frameWithIsland = frame
+	frameWithIsland.Edges = append(append([]Edge{}, frame.Edges...), squareEdges(13, 13, 4, false)...)
+	if !ShapesIntersect(frameWithIsland, island, settings) {
+		t.Errorf("Expected shape with a path on an island in the hole to intersect it\n")
+	}
+	// Case 4: Circle in the hole
+	// Expect false
+	var circle = Shape{IsCircle: true, Cx: 15, Cy: 15, Radius: 2, FilledIn: true}
+	if ShapesIntersect(frame, circle, settings) {
+		t.Errorf("Expected circle in the hole of an evenodd shape to not intersect it\n")
+	}
+	// Case 5: Circle crossing the edges of the hole, but not its corners
+	// Expect true
+	circle.Radius = 6
+	if !ShapesIntersect(frame, circle, settings) {
+		t.Errorf("Expected circle crossing the edges of a hole to intersect the shape\n")
+	}
+}
+
+func TestParseSvgPathRings(t *testing.T) {
+	setUpCanvas(100, 100)
+	// A square with a square hole, whose ring starts with a relative m after the square is closed
+	shape, err := ParseSvgPath("M 0 0 h 10 v 10 h -10 z m 2 2 h 6 v 6 h -6 z")
+	if err != nil {
+		t.Errorf("Received error %v\n", err)
+		return
+	}
+	var expected = append(squareEdges(0, 0, 10, false), squareEdges(2, 2, 6, false)...)
+	if len(shape.Edges) != len(expected) {
+		t.Errorf("Expected %d edges, received %v\n", len(expected), shape.Edges)
+		return
+	}
+	for i := range expected {
+		if shape.Edges[i] != expected[i] {
+			t.Errorf("Expected edge %v, received %v\n", expected[i], shape.Edges[i])
+		}
+	}
+	shape.FilledIn = true
+	shape.FillRule = EVENODD
+	area, err := getAreaOfShape(shape)
+	if err != nil || !floatEquals(area, 64) {
+		t.Errorf("Expected area of square with a hole to be 64, received %f, %v\n", area, err)
+	}
+}
+
 func TestShapesIntersect(t *testing.T) {
 	setUpCanvas(100, 100)
 	// Case 1: Two line shapes intersect
@@ -569,6 +706,48 @@ func TestEdgesIntersect(t *testing.T) {
 	}
 }
 
+func TestClassifyEdgesCollinear(t *testing.T) {
+	// Case 1: One edge inside the other
+	// Expect overlap
+	var edge1 = Edge{Start:Point{0,0}, End:Point{10,0}}
+	var edge2 = Edge{Start:Point{1,0}, End:Point{5,0}}
+	if contact, _ := classifyEdges(edge1, edge2); contact != overlapContact {
+		t.Errorf("Edges %v, %v should overlap, got contact %v \n", edge1, edge2, contact)
+	}
+	// Case 2: Diagonal edges in opposite directions that share part of their line
+	// Expect overlap
+	edge1 = Edge{Start:Point{0,0}, End:Point{3,3}}
+	edge2 = Edge{Start:Point{5,5}, End:Point{1,1}}
+	if contact, _ := classifyEdges(edge1, edge2); contact != overlapContact {
+		t.Errorf("Edges %v, %v should overlap, got contact %v \n", edge1, edge2, contact)
+	}
+	// Case 3: Edges on one line that only share an end point
+	// Expect point contact at the shared end point
+	edge2 = Edge{Start:Point{3,3}, End:Point{5,5}}
+	if contact, point := classifyEdges(edge1, edge2); contact != pointContact || point != (Point{3,3}) {
+		t.Errorf("Edges %v, %v should touch at {3 3}, got contact %v at %v \n", edge1, edge2, contact, point)
+	}
+	// Case 4: Edges on one line with a gap between them
+	// Expect no contact
+	edge2 = Edge{Start:Point{4,4}, End:Point{5,5}}
+	if contact, _ := classifyEdges(edge1, edge2); contact != noContact {
+		t.Errorf("Edges %v, %v should not touch, got contact %v \n", edge1, edge2, contact)
+	}
+	// Case 5: Parallel edges whose bounding boxes overlap, but not on one line
+	// Expect no contact
+	edge2 = Edge{Start:Point{1,0}, End:Point{3,2}}
+	if contact, _ := classifyEdges(edge1, edge2); contact != noContact {
+		t.Errorf("Edges %v, %v should not touch, got contact %v \n", edge1, edge2, contact)
+	}
+	// Case 6: Overlapping edges at coordinates that aren't whole numbers
+	// Expect overlap
+	edge1 = Edge{Start:Point{0.5,0.25}, End:Point{2.5,1.25}}
+	edge2 = Edge{Start:Point{1.5,0.75}, End:Point{4.5,2.25}}
+	if contact, _ := classifyEdges(edge1, edge2); contact != overlapContact {
+		t.Errorf("Edges %v, %v should overlap, got contact %v \n", edge1, edge2, contact)
+	}
+}
+
 func TestOnlyIntersectsAtEndPoints(t *testing.T) {
 	// Case 1: Only point1 touches edge
 	// Expect true
@@ -610,15 +789,143 @@ func TestBoxesIntersect(t *testing.T) {
 }
 
 func TestPointInShape(t *testing.T) {
-	// write these tests if bug encountered in one of its callers
+	// Diamond whose left and right vertices are on the ray cast from its centre
+	var diamond = Shape{Edges: []Edge{
+		{Start: Point{10, 0}, End: Point{20, 10}},
+		{Start: Point{20, 10}, End: Point{10, 20}},
+		{Start: Point{10, 20}, End: Point{0, 10}},
+		{Start: Point{0, 10}, End: Point{10, 0}},
+	}}
+	// Case 1: Ray passes through a vertex
+	// Expect true
+	if !pointInShape(Point{10, 10}, diamond) {
+		t.Errorf("Expected point %v to be in shape %v\n", Point{10, 10}, diamond)
+	}
+	// Case 2: Point left of the shape, ray passes through two vertices
+	// Expect false
+	if pointInShape(Point{-5, 10}, diamond) {
+		t.Errorf("Expected point %v to not be in shape %v\n", Point{-5, 10}, diamond)
+	}
+	// Case 3: Point on an edge
+	// Expect true
+	if !pointInShape(Point{15, 5}, diamond) {
+		t.Errorf("Expected point %v on an edge to be in shape %v\n", Point{15, 5}, diamond)
+	}
+}
 
+func TestOrientation(t *testing.T) {
+	// Case 1: Left, right and on the line
+	if orientation(Point{0, 0}, Point{10, 0}, Point{5, 5}) != 1 {
+		t.Errorf("Expected point to be left of the line\n")
+	}
+	if orientation(Point{0, 0}, Point{10, 0}, Point{5, -5}) != -1 {
+		t.Errorf("Expected point to be right of the line\n")
+	}
+	if orientation(Point{0, 0}, Point{10, 10}, Point{3, 3}) != 0 {
+		t.Errorf("Expected point to be on the line\n")
+	}
+	// Case 2: Point a rounding error away from the line
+	// Expect it not to be on the line
+	if orientation(Point{0, 0}, Point{1, 1}, Point{0.1, 0.1 + 1e-12}) != 1 {
+		t.Errorf("Expected point just above the line to be left of it\n")
+	}
 }
 
-func TestPointsAreOnSameLine(t *testing.T) {
-	// write these tests if bug encountered in one of its callers
+func TestHashShape(t *testing.T) {
+	shape := Shape{Edges: []Edge{{Point{0, 0}, Point{5, 5}}}, BorderColor: "#ff0000", FillColor: TRANSPARENT}
+	encoded := []byte(fmt.Sprintf("%v", shape))
 
+	// Case 1: MD5 networks append the digest of nothing to the encoded shape
+	emptySum := md5.Sum(nil)
+	expected := hex.EncodeToString(append(encoded, emptySum[:]...))
+	if hash := HashShape(shape, BlockVersionMD5); hash != expected {
+		t.Errorf("Expected %s, got %s\n", expected, hash)
+	}
+	// Case 2: SHA-256 networks hash the encoded shape
+	sum := sha256.Sum256(encoded)
+	if hash := HashShape(shape, BlockVersionSHA256); hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected %s, got %s\n", hex.EncodeToString(sum[:]), hash)
+	}
+	// Case 3: The order of the edges does not matter
+	first := Shape{Edges: []Edge{{Point{0, 0}, Point{1, 1}}, {Point{1, 1}, Point{0, 2}}}}
+	second := Shape{Edges: []Edge{{Point{1, 1}, Point{0, 2}}, {Point{0, 0}, Point{1, 1}}}}
+	if HashShape(first, BlockVersionSHA256) != HashShape(second, BlockVersionSHA256) {
+		t.Errorf("Expected shapes with the same edges to have the same hash\n")
+	}
 }
 
-func TestFindNextEdge(t *testing.T) {
-	// write these tests if bug encountered in one of its callers
-}
\ No newline at end of file
+func TestSvgStringLayer(t *testing.T) {
+	svgStrings := map[string]uint8{
+		`<path d="M 0 0 L 5 5" stroke="red" fill="transparent" data-layer="3"/>`: 3,
+		`<path d="M 0 0 L 5 5" stroke="red" fill="transparent"/>`:                 0,
+		`<path d="M 0 0 L 5 5" stroke="red" fill="transparent" data-layer="x"/>`: 0,
+		`<path d="M 0 0 L 5 5" stroke="red" fill="transparent" data-layer="300"/>`: 0,
+	}
+	for svgString, expected := range svgStrings {
+		if layer := SvgStringLayer(svgString); layer != expected {
+			t.Errorf("Expected layer %d for %s, got %d\n", expected, svgString, layer)
+		}
+	}
+}
+
+func TestShapeInRegion(t *testing.T) {
+	setUpCanvas(100, 100)
+	region := Box{MinX: 10, MinY: 10, MaxX: 50, MaxY: 50}
+	// Case 1: A line entirely inside the region
+	// Expect true
+	shape := Shape{Edges: []Edge{{Start: Point{20, 20}, End: Point{30, 30}}}}
+	if !ShapeInRegion(shape, region, canvasT.settings) {
+		t.Errorf("Shape %v should be in region %v \n", shape, region)
+	}
+	// Case 2: A line crossing the region's border
+	// Expect true
+	shape = Shape{Edges: []Edge{{Start: Point{0, 20}, End: Point{30, 20}}}}
+	if !ShapeInRegion(shape, region, canvasT.settings) {
+		t.Errorf("Shape %v should be in region %v \n", shape, region)
+	}
+	// Case 3: A line outside the region
+	// Expect false
+	shape = Shape{Edges: []Edge{{Start: Point{60, 60}, End: Point{70, 70}}}}
+	if ShapeInRegion(shape, region, canvasT.settings) {
+		t.Errorf("Shape %v should not be in region %v \n", shape, region)
+	}
+}
+
+func TestRegionsOverlap(t *testing.T) {
+	region := Box{MinX: 10, MinY: 10, MaxX: 50, MaxY: 50}
+	if !RegionsOverlap(region, Box{MinX: 40, MinY: 40, MaxX: 60, MaxY: 60}) {
+		t.Errorf("Expected regions to overlap \n")
+	}
+	// regions that only share a border do not overlap
+	if RegionsOverlap(region, Box{MinX: 50, MinY: 10, MaxX: 60, MaxY: 50}) {
+		t.Errorf("Expected regions sharing a border not to overlap \n")
+	}
+}
+
+func TestSvgStringCanvas(t *testing.T) {
+	svgString := `<path d="M 0 0 L 5 5" stroke="red" fill="transparent" data-layer="0" data-canvas="mural-1"/>`
+	if canvasID := SvgStringCanvas(svgString); canvasID != "mural-1" {
+		t.Errorf("Expected canvas mural-1 for %s, got %s\n", svgString, canvasID)
+	}
+	// shapes on the network's own canvas don't name it
+	svgString = `<path d="M 0 0 L 5 5" stroke="red" fill="transparent" data-layer="0"/>`
+	if canvasID := SvgStringCanvas(svgString); canvasID != "" {
+		t.Errorf("Expected no canvas for %s, got %s\n", svgString, canvasID)
+	}
+}
+
+func TestShapeBounds(t *testing.T) {
+	// Case 1: Path
+	var triangle = Shape{Edges: []Edge{
+		{Start: Point{10, 20}, End: Point{30, 5}},
+		{Start: Point{30, 5}, End: Point{15, 40}},
+		{Start: Point{15, 40}, End: Point{10, 20}}}}
+	if bounds := ShapeBounds(triangle); bounds != (Box{MinX: 10, MinY: 5, MaxX: 30, MaxY: 40}) {
+		t.Errorf("Expected triangle to be bounded by (10, 5) to (30, 40), got %v\n", bounds)
+	}
+	// Case 2: Circle
+	var circle = Shape{IsCircle: true, Cx: 20, Cy: 30, Radius: 5}
+	if bounds := ShapeBounds(circle); bounds != (Box{MinX: 15, MinY: 25, MaxX: 25, MaxY: 35}) {
+		t.Errorf("Expected circle to be bounded by (15, 25) to (25, 35), got %v\n", bounds)
+	}
+}
@@ -10,9 +10,13 @@ package blockartlib
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
+	"hash"
+	"math/big"
 	"net/rpc"
 	"os"
 	"sync"
@@ -26,10 +30,42 @@ const (
 	PATH        ShapeType = 1
 	// Circle shape (extra credit).
 	CIRCLE		ShapeType = 2
+	// Text drawn with the strokes of a built-in font; see ParseSvgText.
+	TEXT        ShapeType = 3
 	EPSILON     float64   = 0.000001
 	TRANSPARENT string    = "transparent"
 )
 
+// Rule that decides which parts of a filled path are inside it, as the svg fill-rule attribute does.
+type FillRule uint8
+
+const (
+	// A point is inside if the path winds around it a non-zero number of times; svg's default.
+	NONZERO FillRule = 0
+	// A point is inside if a ray from it crosses the path an odd number of times.
+	EVENODD FillRule = 1
+)
+
+// Returns the value of the svg fill-rule attribute for the rule.
+func (rule FillRule) String() string {
+	switch rule {
+	case NONZERO:
+		return "nonzero"
+	case EVENODD:
+		return "evenodd"
+	}
+	return fmt.Sprintf("FillRule(%d)", uint8(rule))
+}
+
+// Block header versions. A network's genesis configuration chooses the version, which
+// selects the algorithm used to hash blocks, ops and shapes.
+const (
+	// MD5 hashing; used by networks whose configuration does not specify a version.
+	BlockVersionMD5 uint8 = 1
+	// SHA-256 hashing.
+	BlockVersionSHA256 uint8 = 2
+)
+
 // Settings for a canvas in BlockArt.
 type CanvasSettings struct {
 	// Canvas dimensions
@@ -37,6 +73,17 @@ type CanvasSettings struct {
 	CanvasYMax uint32
 }
 
+// Rules a canvas created on the network enforces on the shapes drawn on it.
+type CanvasRules struct {
+	// If true, only the miner that created the canvas may draw on it.
+	CreatorOnly bool
+	// Most ink a single shape on the canvas may use; 0 for no limit.
+	MaxShapeInk uint32
+	// Highest layer shapes on the canvas may be drawn on; see Shape.Layer. Drawing on a canvas that allows
+	// layers is consent to being drawn over, so the default of 0 allows only the base layer.
+	MaxLayer uint8
+}
+
 // Settings for an instance of the BlockArt project/network.
 type MinerNetSettings struct {
 	// Hash of the very first (empty) block in the chain.
@@ -59,8 +106,14 @@ type MinerNetSettings struct {
 	PoWDifficultyOpBlock   uint8
 	PoWDifficultyNoOpBlock uint8
 
+	// Block header version, which selects the hashing algorithm (0 is treated as BlockVersionMD5)
+	BlockVersion uint8
+
 	// Canvas settings
 	CanvasSettings CanvasSettings
+
+	// Prices of ink by color
+	InkPricing InkPricing
 }
 
 type Hash []byte
@@ -69,6 +122,47 @@ func (h Hash) ToString() string {
 	return hex.EncodeToString(h)
 }
 
+// Returns the block version that a network with the given configured version uses.
+// Networks configured before versions existed leave it unset, and keep hashing with MD5.
+// @param version uint8: the configured block version
+// @return uint8: the block version in use
+func EffectiveBlockVersion(version uint8) uint8 {
+	if version == 0 {
+		return BlockVersionMD5
+	}
+	return version
+}
+
+// Returns a new hash.Hash computing the algorithm selected by the block version.
+// @param version uint8: the block version
+// @return hash.Hash: SHA-256 for BlockVersionSHA256, MD5 otherwise
+func NewHasher(version uint8) hash.Hash {
+	if version == BlockVersionSHA256 {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+// Returns the hash of a block, computed from its header alone; the block's ops are
+// committed to through the header's MerkleRoot. header.Hash is not part of the hash.
+// @param header BlockHeader: the header of the block
+// @return Hash: the hash of the block
+func HashBlockHeader(header BlockHeader) Hash {
+	s := fmt.Sprintf("%d|%s|%s|%s|%d|%s|%s|%d|%d", header.Version, header.PrevHash, header.MerkleRoot.ToString(),
+		header.StateRoot.ToString(), header.Len, header.Miner, header.Nonce, header.Timestamp, header.NumOps)
+	return HashBytes(header.Version, []byte(s))
+}
+
+// Hashes data with the algorithm selected by the block version.
+// @param version uint8: the block version
+// @param data []byte: the data to hash
+// @return Hash: the hash of data
+func HashBytes(version uint8, data []byte) Hash {
+	hasher := NewHasher(version)
+	hasher.Write(data)
+	return Hash(hasher.Sum(nil))
+}
+
 type Point struct {
 	X, Y float64
 }
@@ -79,6 +173,28 @@ type Edge struct {
 
 type Edges []Edge
 
+// Header of a block in the blockchain: everything in the block except its ops.
+type BlockHeader struct {
+	// Block header version, which selects the hashing algorithm.
+	Version  uint8
+	Hash     string
+	PrevHash string
+	Len      int
+	// Public key of the miner that mined the block.
+	Miner string
+	Nonce string
+	// Time at which the block was mined, in nanoseconds since the Unix epoch.
+	Timestamp int64
+	// Number of ops in the block.
+	NumOps int
+	// Signature of the block hash by the miner.
+	R, S *big.Int
+	// Merkle root of the hashes of the ops in the block.
+	MerkleRoot Hash
+	// Commitment to the canvas (live shapes and ink balances) after the block.
+	StateRoot Hash
+}
+
 type ShapeMeta struct {
 	Hash  string
 	Shape Shape
@@ -87,16 +203,53 @@ type ShapeMeta struct {
 type Shape struct {
 	Timestamp   int64
 	Svg         string
-	Edges       Edges
+	Edges       Edges // may form several closed rings, such as the outline of a shape and its holes
 	FilledIn    bool
-	FillColor   string //todo: hex?
-	BorderColor string //todo: hex?
-	Ink         uint32 //todo: are there different ink levels for different colors?
+	FillColor   string // canonical form of the fill color; see NormalizeColor
+	BorderColor string // canonical form of the stroke color; see NormalizeColor
+	FillRule    FillRule // decides which parts of a filled path are inside it
+	Transform   string // svg transform applied to the shape; Edges, Cx, Cy and Radius are transformed
+	Ink         uint32 // fill and border are each charged at the price of their color; see InkPricing
 	// ---- Circle properties, only access these if IsCircle is true!
 	IsCircle	bool // zero value of a boolean is false
 	Radius		float64
 	Cx			float64
 	Cy			float64
+	// Text shapes are drawn with strokes and have no fill; Svg is "x,y,size,text", and Edges are the strokes.
+	IsText bool
+	// Layer the shape is drawn on. Shapes on different layers may overlap, and shapes on higher layers
+	// are drawn over those on lower layers. The zero value is the base layer, which is the only layer of
+	// the network's own canvas; created canvases have the layers their rules allow (see CanvasRules.MaxLayer).
+	Layer uint8
+	// ID of the canvas the shape is drawn on; empty for the network's own canvas.
+	Canvas string
+}
+
+// What the longest chain says about a shape on the canvas.
+type ShapeInfo struct {
+	Hash string
+	// Hex encoding of the public key of the shape's current owner, who may delete it and receive its refund.
+	Owner string
+	// Ink the shape used.
+	Ink uint32
+	// Layer the shape is drawn on.
+	Layer uint8
+	// ID of the canvas the shape is drawn on; empty for the network's own canvas.
+	Canvas string
+}
+
+// Optional settings for an operation.
+type OpOptions struct {
+	// Ink paid to the miner of the block that includes the operation, on top of the ink the
+	// operation itself uses. Miners include operations offering higher fees first.
+	Fee uint32
+	// Layer a shape added or updated by the operation is drawn on; see Shape.Layer.
+	Layer uint8
+	// Fill rule of a shape added or updated by the operation; see FillRule.
+	FillRule FillRule
+	// Transform, in the form of the svg transform attribute, that moves, rotates or scales a shape added
+	// or updated by the operation before it is drawn; see ParseTransform. Empty for none.
+	Transform string
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
@@ -166,6 +319,71 @@ func (e InvalidBlockHashError) Error() string {
 	return fmt.Sprintf("BlockArt: Invalid block hash [%s]", string(e))
 }
 
+// Contains the hash of the block or shape that failed verification in verifying mode.
+type VerificationError string
+
+func (e VerificationError) Error() string {
+	return fmt.Sprintf("BlockArt: Miner returned data that failed verification [%s]", string(e))
+}
+
+// Contains the public key of the owner whose pending ops are over the miner's limit;
+// empty if the miner's mempool as a whole is full.
+type MempoolFullError string
+
+func (e MempoolFullError) Error() string {
+	return fmt.Sprintf("BlockArt: Too many pending operations [%s]", string(e))
+}
+
+// Contains the public key of the recipient of an ink transfer that is not valid.
+type InvalidTransferError string
+
+func (e InvalidTransferError) Error() string {
+	return fmt.Sprintf("BlockArt: Invalid ink transfer to [%s]", string(e))
+}
+
+// Contains the hash of the active claim on the region that a shape was drawn in without the right to do so.
+type RegionClaimedError string
+
+func (e RegionClaimedError) Error() string {
+	return fmt.Sprintf("BlockArt: Shape is in a region claimed by someone else [%s]", string(e))
+}
+
+// Contains the hash of the claim that could not be made or granted; empty if the claim's region, length
+// or deposit is not valid.
+type InvalidClaimError string
+
+func (e InvalidClaimError) Error() string {
+	return fmt.Sprintf("BlockArt: Invalid region claim [%s]", string(e))
+}
+
+// Contains the ID of a canvas that does not exist, cannot be created, or whose rules forbid the operation.
+type InvalidCanvasError string
+
+func (e InvalidCanvasError) Error() string {
+	return fmt.Sprintf("BlockArt: Invalid canvas [%s]", string(e))
+}
+
+// Contains the fill or stroke that is not a color; see ParseColor for the colors accepted.
+type InvalidColorError string
+
+func (e InvalidColorError) Error() string {
+	return fmt.Sprintf("BlockArt: Invalid color [%s]", string(e))
+}
+
+// Contains a key of InkPricing.Colors that is the same color as another key.
+type DuplicateColorError string
+
+func (e DuplicateColorError) Error() string {
+	return fmt.Sprintf("BlockArt: Color priced more than once [%s]", string(e))
+}
+
+// Contains the transform that is not one; see ParseTransform for the transforms accepted.
+type InvalidTransformError string
+
+func (e InvalidTransformError) Error() string {
+	return fmt.Sprintf("BlockArt: Invalid transform [%s]", string(e))
+}
+
 // Empty
 type OutOfBoundsError struct{}
 
@@ -186,8 +404,28 @@ type Canvas interface {
 	// - ShapeSvgStringTooLongError
 	// - ShapeOverlapError
 	// - OutOfBoundsError
+	// - MempoolFullError
+	// - RegionClaimedError
+	// - InvalidCanvasError
+	// - InvalidColorError
+	// - InvalidTransformError
 	AddShape(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error)
 
+	// Adds a new shape to the canvas, as AddShape, with the passed options.
+	// Can return the same errors as AddShape.
+	AddShapeWithOptions(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string, options OpOptions) (shapeHash string, blockHash string, inkRemaining uint32, err error)
+
+	// Replaces the shape identified by shapeHash by a new shape, in one operation, so the space cannot be
+	// taken by someone else in between. Only the difference in ink between the two shapes is charged.
+	// Returns the hash of the new shape.
+	// Can return the errors AddShape can, and:
+	// - ShapeOwnerError
+	UpdateShape(validateNum uint8, shapeHash string, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (newShapeHash string, blockHash string, inkRemaining uint32, err error)
+
+	// Replaces a shape, as UpdateShape, with the passed options.
+	// Can return the same errors as UpdateShape.
+	UpdateShapeWithOptions(validateNum uint8, shapeHash string, shapeType ShapeType, shapeSvgString string, fill string, stroke string, options OpOptions) (newShapeHash string, blockHash string, inkRemaining uint32, err error)
+
 	// Returns the encoding of the shape as an svg string.
 	// Can return the following errors:
 	// - DisconnectedError
@@ -203,8 +441,116 @@ type Canvas interface {
 	// Can return the following errors:
 	// - DisconnectedError
 	// - ShapeOwnerError
+	// - MempoolFullError
 	DeleteShape(validateNum uint8, shapeHash string) (inkRemaining uint32, err error)
 
+	// Removes a shape from the canvas, as DeleteShape, with the passed options.
+	// Can return the same errors as DeleteShape, and:
+	// - InsufficientInkError
+	DeleteShapeWithOptions(validateNum uint8, shapeHash string, options OpOptions) (inkRemaining uint32, err error)
+
+	// Transfers amount ink to the miner identified by toPubKey.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InsufficientInkError
+	// - InvalidTransferError
+	// - MempoolFullError
+	TransferInk(toPubKey ecdsa.PublicKey, amount uint32, validateNum uint8) (inkRemaining uint32, err error)
+
+	// Transfers ink, as TransferInk, with the passed options.
+	// Can return the same errors as TransferInk.
+	TransferInkWithOptions(toPubKey ecdsa.PublicKey, amount uint32, validateNum uint8, options OpOptions) (inkRemaining uint32, err error)
+
+	// Hands the shape identified by shapeHash to the miner identified by toPubKey, along with the right
+	// to delete it and receive its ink refund. A shape that overlaps another of this miner's shapes can't be
+	// handed over, as it would then overlap a shape of someone else's.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InsufficientInkError
+	// - ShapeOwnerError
+	// - InvalidTransferError
+	// - ShapeOverlapError
+	// - MempoolFullError
+	TransferShape(shapeHash string, toPubKey ecdsa.PublicKey, validateNum uint8) (inkRemaining uint32, err error)
+
+	// Hands over a shape, as TransferShape, with the passed options.
+	// Can return the same errors as TransferShape.
+	TransferShapeWithOptions(shapeHash string, toPubKey ecdsa.PublicKey, validateNum uint8, options OpOptions) (inkRemaining uint32, err error)
+
+	// Reserves region of the canvas for blocks blocks: until the claim expires, only this miner and the
+	// miners it grants the region to may draw shapes that touch it. deposit ink, at least one per pixel
+	// of the region, is held until the claim expires and then refunded. Returns the hash of the claim.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InsufficientInkError
+	// - InvalidClaimError
+	// - RegionClaimedError
+	// - MempoolFullError
+	ClaimRegion(region Box, blocks uint32, deposit uint32, validateNum uint8) (claimHash string, inkRemaining uint32, err error)
+
+	// Reserves a region, as ClaimRegion, with the passed options.
+	// Can return the same errors as ClaimRegion.
+	ClaimRegionWithOptions(region Box, blocks uint32, deposit uint32, validateNum uint8, options OpOptions) (claimHash string, inkRemaining uint32, err error)
+
+	// Lets the miner identified by toPubKey draw in the region of the claim identified by claimHash,
+	// which must be an active claim made by this miner.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InsufficientInkError
+	// - InvalidClaimError
+	// - InvalidTransferError
+	// - MempoolFullError
+	GrantRegion(claimHash string, toPubKey ecdsa.PublicKey, validateNum uint8) (inkRemaining uint32, err error)
+
+	// Grants a region, as GrantRegion, with the passed options.
+	// Can return the same errors as GrantRegion.
+	GrantRegionWithOptions(claimHash string, toPubKey ecdsa.PublicKey, validateNum uint8, options OpOptions) (inkRemaining uint32, err error)
+
+	// Creates a new canvas on the network, identified by canvasID, with the passed dimensions and rules.
+	// IDs are made of letters, digits, '-' and '_'. Apps work on the canvas by opening it with OpenCanvasWithID.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InsufficientInkError
+	// - InvalidCanvasError
+	// - MempoolFullError
+	CreateCanvas(canvasID string, settings CanvasSettings, rules CanvasRules, validateNum uint8) (inkRemaining uint32, err error)
+
+	// Creates a canvas, as CreateCanvas, with the passed options.
+	// Can return the same errors as CreateCanvas.
+	CreateCanvasWithOptions(canvasID string, settings CanvasSettings, rules CanvasRules, validateNum uint8, options OpOptions) (inkRemaining uint32, err error)
+
+	// Checks a shape as AddShape would, against the canvas at the miner's current head, without adding it.
+	// Returns the ink the shape would use and its bounding box. Ops that aren't in a block yet are not
+	// taken into account, so a shape that passes may still be refused when it is added.
+	// Can return the errors AddShape can, except MempoolFullError.
+	ValidateShape(shapeType ShapeType, shapeSvgString string, fill string, stroke string) (ink uint32, bounds Box, err error)
+
+	// Checks a shape, as ValidateShape, as if it were added with the passed options.
+	// Can return the same errors as ValidateShape.
+	ValidateShapeWithOptions(shapeType ShapeType, shapeSvgString string, fill string, stroke string, options OpOptions) (ink uint32, bounds Box, err error)
+
+	// Finds places on the canvas, as of the miner's current head, where a box of the passed size touches no
+	// shape of another miner on the base layer and no region claimed by another miner. Any shape that fits
+	// in the box can be drawn there without overlapping; ValidateShape returns the bounding box of a shape,
+	// and a Transform can move the shape to a place found.
+	// Returns the top left corners of up to maxResults such boxes, by row from the top, then from the left.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidCanvasError
+	// - OutOfBoundsError: the box is empty, or larger than the canvas
+	FindFreeSpace(width float64, height float64, maxResults int) (positions []Point, err error)
+
+	// Finds places on the canvas, as FindFreeSpace, on options.Layer.
+	// Can return the same errors as FindFreeSpace, and InvalidCanvasError if the canvas's rules don't allow
+	// options.Layer.
+	FindFreeSpaceWithOptions(width float64, height float64, maxResults int, options OpOptions) (positions []Point, err error)
+
+	// Retrieves the current owner and ink of the shape identified by shapeHash.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidShapeHashError
+	GetShapeInfo(shapeHash string) (info ShapeInfo, err error)
+
 	// Retrieves hashes contained by a specific block.
 	// Can return the following errors:
 	// - DisconnectedError
@@ -222,6 +568,28 @@ type Canvas interface {
 	// - InvalidBlockHashError
 	GetChildren(blockHash string) (blockHashes []string, err error)
 
+	// Retrieves the header of the block identified by blockHash.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidBlockHashError
+	GetBlockHeader(blockHash string) (header BlockHeader, err error)
+
+	// Retrieves a proof that the shape identified by shapeHash was added in a block on the
+	// longest chain. Check it with VerifyShapeProof against the header of proof.BlockHash.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidShapeHashError
+	GetShapeProof(shapeHash string) (proof ShapeProof, err error)
+
+	// Checks that the shape identified by shapeHash is in a block on the miner's longest chain without
+	// trusting the miner: the chain's headers and the shape's inclusion proof are verified locally.
+	// Returns the hash of the block containing the shape.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidShapeHashError
+	// - VerificationError
+	VerifyShape(shapeHash string) (blockHash string, err error)
+
 	// Closes the canvas/connection to the BlockArt network.
 	// - DisconnectedError
 	CloseCanvas() (inkRemaining uint32, err error)
@@ -243,12 +611,24 @@ var once sync.Once
 // Can return the following errors:
 // - DisconnectedError
 func OpenCanvas(minerAddr string, privKey ecdsa.PrivateKey) (canvas Canvas, setting CanvasSettings, err error) {
+	return OpenCanvasWithID(minerAddr, privKey, "")
+}
+
+// Like OpenCanvas, but the returned Canvas works on the canvas identified by canvasID, created on the
+// network with CreateCanvas; the empty ID selects the network's own canvas. The returned settings are
+// those of the selected canvas.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidCanvasError
+func OpenCanvasWithID(minerAddr string, privKey ecdsa.PrivateKey, canvasID string) (canvas Canvas, setting CanvasSettings, err error) {
 	once.Do(func() {
 		canvasT = &CanvasInstance{}
 	})
 
 	canvasT.minerAddr = minerAddr
 	canvasT.privKey = privKey
+	canvasT.canvasID = canvasID
 	canvasT.closed = true
 
 	// connect to miner
@@ -257,20 +637,52 @@ func OpenCanvas(minerAddr string, privKey ecdsa.PrivateKey) (canvas Canvas, sett
 	}
 
 	gob.Register(&elliptic.CurveParams{})
-	openCanvasArgs := &OpenCanvasArgs{Priv: privKey, Pub: privKey.PublicKey}
+	gob.Register(InvalidCanvasError(""))
+	openCanvasArgs := &OpenCanvasArgs{Priv: privKey, Pub: privKey.PublicKey, CanvasID: canvasID}
 	var openCanvasReply OpenCanvasReply
 	if err = canvasT.client.Call("LibMin.OpenCanvasIM", openCanvasArgs, &openCanvasReply); err != nil {
 		setting = openCanvasReply.CanvasSettings
 		return canvasT, setting, DisconnectedError(minerAddr)
 	}
+	if openCanvasReply.Error != nil {
+		return canvasT, setting, openCanvasReply.Error
+	}
 	setting = openCanvasReply.CanvasSettings
 
 	canvasT.settings = setting
+	canvasT.netSettings = MinerNetSettings{
+		GenesisBlockHash:       openCanvasReply.GenesisBlockHash,
+		PoWDifficultyOpBlock:   openCanvasReply.PoWDifficultyOpBlock,
+		PoWDifficultyNoOpBlock: openCanvasReply.PoWDifficultyNoOpBlock,
+		BlockVersion:           EffectiveBlockVersion(openCanvasReply.BlockVersion),
+		CanvasSettings:         setting,
+		InkPricing:             openCanvasReply.InkPricing,
+	}
+	canvasT.verifying = false
+	canvasT.headers = &headerCache{}
 	canvasT.closed = false
 
 	return canvasT, setting, nil
 }
 
+// Like OpenCanvas, but returns a Canvas in verifying mode: rather than trusting the miner, the library
+// downloads the chain's block headers, checks their proof-of-work, signatures and length itself, and checks
+// that added shapes are in the chain with inclusion proofs.
+// Only AddShape, UpdateShape and VerifyShape (and their WithOptions forms) are verified. Every other call,
+// including GetSvgString, GetShapes and GetChildren, returns the miner's answer unchecked.
+//
+// Can return the following errors:
+// - DisconnectedError
+func OpenVerifyingCanvas(minerAddr string, privKey ecdsa.PrivateKey) (canvas Canvas, setting CanvasSettings, err error) {
+	canvas, setting, err = OpenCanvas(minerAddr, privKey)
+	if err != nil {
+		return canvas, setting, err
+	}
+
+	canvasT.verifying = true
+	return canvas, setting, nil
+}
+
 // Renders the canvas in HTML.
 func PaintCanvas() {
 	htmlContent := []byte("hello\ngo\n")
@@ -0,0 +1,66 @@
+package blockartlib
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestEffectiveBlockVersion(t *testing.T) {
+	versions := map[uint8]uint8{
+		0:                  BlockVersionMD5,
+		BlockVersionMD5:    BlockVersionMD5,
+		BlockVersionSHA256: BlockVersionSHA256,
+	}
+	for configured, expected := range versions {
+		if version := EffectiveBlockVersion(configured); version != expected {
+			t.Errorf("Expected version %d for configured version %d, got %d\n", expected, configured, version)
+		}
+	}
+}
+
+func TestHashBytes(t *testing.T) {
+	data := []byte("blockart")
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+
+	// Case 1: MD5 networks, including those that predate versions once their version is made effective
+	for _, version := range []uint8{BlockVersionMD5, EffectiveBlockVersion(0)} {
+		if hash := HashBytes(version, data); hash.ToString() != Hash(md5Sum[:]).ToString() {
+			t.Errorf("Expected MD5 hash for version %d, got %s\n", version, hash.ToString())
+		}
+	}
+	// Case 2: SHA-256 networks
+	if hash := HashBytes(BlockVersionSHA256, data); hash.ToString() != Hash(sha256Sum[:]).ToString() {
+		t.Errorf("Expected SHA-256 hash, got %s\n", hash.ToString())
+	}
+	if size := NewHasher(BlockVersionSHA256).Size(); size != sha256.Size {
+		t.Errorf("Expected a %d byte hasher for SHA-256, got %d\n", sha256.Size, size)
+	}
+}
+
+func TestHashBlockHeader(t *testing.T) {
+	header := BlockHeader{Version: BlockVersionSHA256, PrevHash: "ab", Len: 2, Miner: "miner", Nonce: "7", Timestamp: 1}
+
+	// Case 1: The hash is of the header's version, and leaves out the header's own hash and signature
+	hash := HashBlockHeader(header)
+	if len(hash) != sha256.Size {
+		t.Errorf("Expected a SHA-256 hash, got %s\n", hash.ToString())
+	}
+	signed := header
+	signed.Hash = hash.ToString()
+	if HashBlockHeader(signed).ToString() != hash.ToString() {
+		t.Errorf("Expected the header's hash not to depend on its Hash field\n")
+	}
+	// Case 2: Every other field is committed to
+	changed := header
+	changed.Timestamp++
+	if HashBlockHeader(changed).ToString() == hash.ToString() {
+		t.Errorf("Expected the header's hash to depend on its timestamp\n")
+	}
+	// Case 3: Headers of version 1 are hashed with MD5
+	header.Version = BlockVersionMD5
+	if hash := HashBlockHeader(header); len(hash) != md5.Size {
+		t.Errorf("Expected an MD5 hash, got %s\n", hash.ToString())
+	}
+}
@@ -0,0 +1,190 @@
+/*
+
+This file is part of the blockartlib package, and contains the parser for the colors that shapes are filled and
+stroked with, and the pricing of ink by color.
+
+*/
+
+package blockartlib
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A color a shape can be filled or stroked with.
+type Color struct {
+	R, G, B uint8
+	// True for the transparent color, which uses no ink; R, G and B are then zero.
+	Transparent bool
+}
+
+// Colors that can be given by name, as in CSS.
+var namedColors = map[string]Color{
+	"black":   {0x00, 0x00, 0x00, false},
+	"silver":  {0xc0, 0xc0, 0xc0, false},
+	"gray":    {0x80, 0x80, 0x80, false},
+	"white":   {0xff, 0xff, 0xff, false},
+	"maroon":  {0x80, 0x00, 0x00, false},
+	"red":     {0xff, 0x00, 0x00, false},
+	"purple":  {0x80, 0x00, 0x80, false},
+	"fuchsia": {0xff, 0x00, 0xff, false},
+	"green":   {0x00, 0x80, 0x00, false},
+	"lime":    {0x00, 0xff, 0x00, false},
+	"olive":   {0x80, 0x80, 0x00, false},
+	"yellow":  {0xff, 0xff, 0x00, false},
+	"navy":    {0x00, 0x00, 0x80, false},
+	"blue":    {0x00, 0x00, 0xff, false},
+	"teal":    {0x00, 0x80, 0x80, false},
+	"aqua":    {0x00, 0xff, 0xff, false},
+	"orange":  {0xff, 0xa5, 0x00, false},
+}
+
+// Returns the canonical form of the color: "transparent", or "#rrggbb" in lower case.
+func (c Color) String() string {
+	if c.Transparent {
+		return TRANSPARENT
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Parses a color. Colors follow this grammar, ignoring case:
+//
+//	color     = "transparent" | name | "#" hex hex hex | "#" hex hex hex hex hex hex | rgb
+//	name      = one of the keys of namedColors
+//	rgb       = "rgb(" spaces component spaces "," spaces component spaces "," spaces component spaces ")"
+//	component = one to three decimal digits, with a value from 0 to 255
+//	spaces    = zero or more " "
+//
+// Nothing else is a color; in particular, there may be no whitespace around it.
+// @param s string: the color
+// @return Color: the parsed color
+// @return error: InvalidColorError iff s is not a color
+func ParseColor(s string) (Color, error) {
+	str := strings.ToLower(s)
+
+	if str == TRANSPARENT {
+		return Color{Transparent: true}, nil
+	}
+	if color, ok := namedColors[str]; ok {
+		return color, nil
+	}
+
+	if strings.HasPrefix(str, "#") {
+		hex := str[1:]
+		if len(hex) == 3 {
+			// #rgb is short for #rrggbb
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 || strings.Trim(hex, "0123456789abcdef") != "" {
+			return Color{}, InvalidColorError(s)
+		}
+		value, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return Color{}, InvalidColorError(s)
+		}
+		return Color{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value)}, nil
+	}
+
+	if strings.HasPrefix(str, "rgb(") && strings.HasSuffix(str, ")") {
+		parts := strings.Split(str[len("rgb("):len(str)-1], ",")
+		if len(parts) != 3 {
+			return Color{}, InvalidColorError(s)
+		}
+		var components [3]uint8
+		for i, part := range parts {
+			digits := strings.Trim(part, " ")
+			if len(digits) == 0 || len(digits) > 3 || strings.Trim(digits, "0123456789") != "" {
+				return Color{}, InvalidColorError(s)
+			}
+			value, err := strconv.ParseUint(digits, 10, 8)
+			if err != nil {
+				return Color{}, InvalidColorError(s)
+			}
+			components[i] = uint8(value)
+		}
+		return Color{R: components[0], G: components[1], B: components[2]}, nil
+	}
+
+	return Color{}, InvalidColorError(s)
+}
+
+// Returns the canonical form of a color, which is what shapes store and are hashed with.
+// @param s string: the color, in any form ParseColor accepts
+// @return string: the color's canonical form; see Color.String
+// @return error: InvalidColorError iff s is not a color
+func NormalizeColor(s string) (string, error) {
+	color, err := ParseColor(s)
+	if err != nil {
+		return "", err
+	}
+	return color.String(), nil
+}
+
+// Prices of ink by color, as percentages of the base price of one unit of ink per pixel.
+type InkPricing struct {
+	// Price of the colors not in Colors; 0 is treated as 100.
+	DefaultPercent uint32
+	// Prices of particular colors, keyed by the colors' canonical forms once normalized; see Normalize.
+	Colors map[string]uint32
+}
+
+// Returns the pricing with each key of Colors, which may be in any form ParseColor accepts, replaced by its
+// canonical form. Pricing should be normalized once, when the settings holding it are loaded.
+// @return InkPricing: the normalized pricing
+// @return error: InvalidColorError if a key is not a color or is transparent (which is always free), or
+// DuplicateColorError if two keys are the same color
+func (p InkPricing) Normalize() (InkPricing, error) {
+	keys := make([]string, 0, len(p.Colors))
+	for key := range p.Colors {
+		keys = append(keys, key)
+	}
+	// sorted, so that the same pricing always fails with the same error
+	sort.Strings(keys)
+
+	normalized := InkPricing{DefaultPercent: p.DefaultPercent, Colors: make(map[string]uint32, len(p.Colors))}
+	for _, key := range keys {
+		color, err := ParseColor(key)
+		if err != nil || color.Transparent {
+			return InkPricing{}, InvalidColorError(key)
+		}
+		if _, ok := normalized.Colors[color.String()]; ok {
+			return InkPricing{}, DuplicateColorError(key)
+		}
+		normalized.Colors[color.String()] = p.Colors[key]
+	}
+	return normalized, nil
+}
+
+// Returns the price of ink of the passed color, as a percentage of the base price. Transparent ink is free.
+// - ASSUMES that p is normalized
+// @param color Color: the color
+// @return uint32: the price, as a percentage
+func (p InkPricing) Percent(color Color) uint32 {
+	if color.Transparent {
+		return 0
+	}
+	if percent, ok := p.Colors[color.String()]; ok {
+		return percent
+	}
+	return p.defaultPercent()
+}
+
+// Returns the price of ink of the color given as a string; colors that do not parse cost the default price.
+func (p InkPricing) percentOf(s string) uint32 {
+	color, err := ParseColor(s)
+	if err != nil {
+		return p.defaultPercent()
+	}
+	return p.Percent(color)
+}
+
+// Returns the price of the colors not in p.Colors.
+func (p InkPricing) defaultPercent() uint32 {
+	if p.DefaultPercent == 0 {
+		return 100
+	}
+	return p.DefaultPercent
+}
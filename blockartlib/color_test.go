@@ -0,0 +1,107 @@
+package blockartlib
+
+import "testing"
+
+func TestParseColor(t *testing.T) {
+	valid := map[string]string{
+		"red":              "#ff0000",
+		"Red":              "#ff0000",
+		"#F00":             "#ff0000",
+		"#00ff7f":          "#00ff7f",
+		"rgb(0, 128, 255)": "#0080ff",
+		"RGB(0,128,255)":   "#0080ff",
+		"transparent":      TRANSPARENT,
+		"Transparent":      TRANSPARENT,
+	}
+	for s, expected := range valid {
+		color, err := ParseColor(s)
+		if err != nil {
+			t.Errorf("Received error %v for %s\n", err, s)
+			continue
+		}
+		if color.String() != expected {
+			t.Errorf("Expected %s for %s, got %s\n", expected, s, color.String())
+		}
+	}
+
+	invalid := []string{"", " red", "reddish", "#ff00", "#gg0000", "#+f0000", "rgb(0, 0)", "rgb(0, 0, 256)",
+		"rgb(0, 0, -1)", "rgb(0, 0, +1)", "rgb(0,\t0, 0)", "rgb(0, 0, 0001)", "red\" onload=\"alert(1)"}
+	for _, s := range invalid {
+		color, err := ParseColor(s)
+		if err == nil {
+			t.Errorf("Expected error for %s, got %s\n", s, color.String())
+		} else if _, ok := err.(InvalidColorError); !ok {
+			t.Errorf("Expected InvalidColorError for %s, got %v\n", s, err)
+		}
+	}
+}
+
+func TestNormalizeColor(t *testing.T) {
+	for _, s := range []string{"lime", "#0F0", "#00ff00", "rgb(0,255,0)"} {
+		normalized, err := NormalizeColor(s)
+		if err != nil || normalized != "#00ff00" {
+			t.Errorf("Expected #00ff00 for %s, got %s, %v\n", s, normalized, err)
+		}
+	}
+}
+
+func TestInkUsedWithPricing(t *testing.T) {
+	// A 10x10 square: 100 pixels of fill, 40 of border
+	var shape = Shape{}
+	shape.FilledIn = true
+	shape.FillColor = "blue"
+	shape.BorderColor = "red"
+	shape.Edges = []Edge{Edge{Start: Point{0, 0}, End: Point{10, 0}},
+		Edge{Start: Point{10, 0}, End: Point{10, 10}},
+		Edge{Start: Point{10, 10}, End: Point{0, 10}},
+		Edge{Start: Point{0, 10}, End: Point{0, 0}}}
+
+	pricing := InkPricing{DefaultPercent: 50, Colors: map[string]uint32{"#ff0000": 200}}
+	ink, err := InkUsedWithPricing(&shape, pricing)
+	if err != nil {
+		t.Errorf("Received error %v \n", err)
+	}
+	// fill at the default 50%, border at 200%
+	if ink != 130 {
+		t.Errorf("Expected 130 units of ink, used %d \n", ink)
+	}
+
+	// no pricing charges every color the same
+	ink, err = InkUsedWithPricing(&shape, InkPricing{})
+	if err != nil {
+		t.Errorf("Received error %v \n", err)
+	}
+	if ink != 140 {
+		t.Errorf("Expected 140 units of ink, used %d \n", ink)
+	}
+}
+
+func TestInkPricingNormalize(t *testing.T) {
+	// Case 1: Keys in any form are looked up by the colors they name
+	pricing, err := InkPricing{DefaultPercent: 50, Colors: map[string]uint32{"Red": 200, "#00F": 300, "rgb(0, 255, 0)": 10}}.Normalize()
+	if err != nil {
+		t.Errorf("Received error %v \n", err)
+	}
+	expected := map[string]uint32{"red": 200, "blue": 300, "lime": 10, "white": 50, TRANSPARENT: 0}
+	for s, percent := range expected {
+		color, _ := ParseColor(s)
+		if pricing.Percent(color) != percent {
+			t.Errorf("Expected %d%% for %s, got %d%%\n", percent, s, pricing.Percent(color))
+		}
+	}
+
+	// Case 2: Keys that are not colors, or are transparent, are rejected
+	for _, key := range []string{"reddish", TRANSPARENT} {
+		if _, err := (InkPricing{Colors: map[string]uint32{key: 200}}).Normalize(); err == nil {
+			t.Errorf("Expected error for key %s\n", key)
+		} else if _, ok := err.(InvalidColorError); !ok {
+			t.Errorf("Expected InvalidColorError for key %s, got %v\n", key, err)
+		}
+	}
+
+	// Case 3: Keys that are the same color are rejected
+	_, err = InkPricing{Colors: map[string]uint32{"red": 200, "#ff0000": 300}}.Normalize()
+	if _, ok := err.(DuplicateColorError); !ok {
+		t.Errorf("Expected DuplicateColorError, got %v\n", err)
+	}
+}
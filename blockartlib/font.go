@@ -0,0 +1,127 @@
+/*
+
+This file is part of the blockartlib package, and contains the single-line font text shapes are drawn
+with, and the parser for the svg strings of text shapes.
+
+*/
+
+package blockartlib
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Height of a capital letter of the font, in font units; y goes down from 0 at the top of the letters.
+	fontCapHeight = 6
+	// Distance from the left of one letter to the left of the next, in font units.
+	fontAdvance = 5
+)
+
+// Strokes of each letter of the font, in font units. Each stroke is a line through the listed "x,y" points;
+// letters are 4 units wide. Lower case letters are drawn as upper case ones.
+var fontStrokes = map[rune][]string{
+	'A':  {"0,6 0,2 2,0 4,2 4,6", "0,3 4,3"},
+	'B':  {"0,0 0,6 3,6 4,5 4,4 3,3 0,3", "0,0 3,0 4,1 4,2 3,3"},
+	'C':  {"4,0 0,0 0,6 4,6"},
+	'D':  {"0,0 0,6 2,6 4,4 4,2 2,0 0,0"},
+	'E':  {"4,0 0,0 0,6 4,6", "0,3 3,3"},
+	'F':  {"4,0 0,0 0,6", "0,3 3,3"},
+	'G':  {"4,0 0,0 0,6 4,6 4,3 2,3"},
+	'H':  {"0,0 0,6", "4,0 4,6", "0,3 4,3"},
+	'I':  {"0,0 4,0", "2,0 2,6", "0,6 4,6"},
+	'J':  {"0,0 4,0", "3,0 3,6 0,6 0,4"},
+	'K':  {"0,0 0,6", "4,0 0,3 4,6"},
+	'L':  {"0,0 0,6 4,6"},
+	'M':  {"0,6 0,0 2,3 4,0 4,6"},
+	'N':  {"0,6 0,0 4,6 4,0"},
+	'O':  {"0,0 4,0 4,6 0,6 0,0"},
+	'P':  {"0,6 0,0 4,0 4,3 0,3"},
+	'Q':  {"0,0 4,0 4,6 0,6 0,0", "2,4 4,6"},
+	'R':  {"0,6 0,0 4,0 4,3 0,3 4,6"},
+	'S':  {"4,1 3,0 1,0 0,1 0,2 1,3 3,3 4,4 4,5 3,6 1,6 0,5"},
+	'T':  {"0,0 4,0", "2,0 2,6"},
+	'U':  {"0,0 0,6 4,6 4,0"},
+	'V':  {"0,0 2,6 4,0"},
+	'W':  {"0,0 1,6 2,3 3,6 4,0"},
+	'X':  {"0,0 4,6", "4,0 0,6"},
+	'Y':  {"0,0 2,3 4,0", "2,3 2,6"},
+	'Z':  {"0,0 4,0 0,6 4,6"},
+	'0':  {"0,0 4,0 4,6 0,6 0,0", "4,0 0,6"},
+	'1':  {"1,1 2,0 2,6", "0,6 4,6"},
+	'2':  {"0,0 4,0 4,3 0,3 0,6 4,6"},
+	'3':  {"0,0 4,0 4,6 0,6", "0,3 4,3"},
+	'4':  {"0,0 0,3 4,3", "4,0 4,6"},
+	'5':  {"4,0 0,0 0,3 4,3 4,6 0,6"},
+	'6':  {"4,0 0,0 0,6 4,6 4,3 0,3"},
+	'7':  {"0,0 4,0 1,6"},
+	'8':  {"0,0 4,0 4,6 0,6 0,0", "0,3 4,3"},
+	'9':  {"4,3 0,3 0,0 4,0 4,6 0,6"},
+	' ':  {},
+	'.':  {"2,5 2,6"},
+	',':  {"2,5 1,7"},
+	'-':  {"1,3 3,3"},
+	'!':  {"2,0 2,4", "2,5 2,6"},
+	'?':  {"0,1 1,0 3,0 4,1 4,2 2,3 2,4", "2,5 2,6"},
+	':':  {"2,1 2,2", "2,4 2,5"},
+	'\'': {"2,0 2,2"},
+	'/':  {"0,6 4,0"},
+	'+':  {"2,1 2,5", "0,3 4,3"},
+	'=':  {"0,2 4,2", "0,4 4,4"},
+}
+
+// Parses the svg string of a text shape into the strokes of its letters.
+// @param svg string: In format "x,y,size,text"
+// - (x, y) = top left corner of the first letter
+// - size = height of a capital letter, in pixels
+// - text = the text; letters, digits, spaces and the characters in .,-!?:'/+=
+// @return *Shape: the text shape, with an edge for each part of a stroke
+// @return error: InvalidShapeSvgStringError iff svg is not the svg string of a text shape
+func ParseSvgText(svg string) (*Shape, error) {
+	svgParts := strings.SplitN(svg, ",", 4)
+	if len(svgParts) != 4 {
+		return nil, InvalidShapeSvgStringError(svg + " is not a valid text string. Use format x,y,size,text")
+	}
+	var numbers [3]float64
+	for i := range numbers {
+		number, err := strconv.ParseFloat(strings.TrimSpace(svgParts[i]), 64)
+		if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+			return nil, InvalidShapeSvgStringError(svg)
+		}
+		numbers[i] = number
+	}
+	x, y, size := numbers[0], numbers[1], numbers[2]
+	if !(size > 0) {
+		return nil, InvalidShapeSvgStringError(svg + " has a text size that is not positive")
+	}
+
+	scale := size / fontCapHeight
+	shape := Shape{IsText: true}
+	for i, letter := range []rune(svgParts[3]) {
+		strokes, ok := fontStrokes[unicode.ToUpper(letter)]
+		if !ok {
+			return nil, InvalidShapeSvgStringError(svg + " has a character the font can't draw")
+		}
+		left := x + float64(i*fontAdvance)*scale
+		for _, stroke := range strokes {
+			var previous Point
+			for j, point := range strings.Split(stroke, " ") {
+				coordinates := strings.Split(point, ",")
+				fontX, _ := strconv.Atoi(coordinates[0])
+				fontY, _ := strconv.Atoi(coordinates[1])
+				current := Point{X: left + float64(fontX)*scale, Y: y + float64(fontY)*scale}
+				if j > 0 {
+					shape.Edges = append(shape.Edges, Edge{Start: previous, End: current})
+				}
+				previous = current
+			}
+		}
+	}
+	if len(shape.Edges) == 0 {
+		return nil, InvalidShapeSvgStringError(svg + " has no text to draw")
+	}
+	return &shape, nil
+}
@@ -0,0 +1,73 @@
+package blockartlib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSvgText(t *testing.T) {
+	// Case 1: "HI" at (10, 20), 12 pixels high; H has 3 edges, and I has 3
+	shape, err := ParseSvgText("10,20,12,HI")
+	if err != nil {
+		t.Errorf("Received error %v\n", err)
+		return
+	}
+	if !shape.IsText || len(shape.Edges) != 6 {
+		t.Errorf("Expected a text shape with 6 edges, got %v\n", shape)
+	}
+	// the left stroke of H, and the bottom stroke of I, which starts one letter width (10 pixels) further
+	for _, edge := range []Edge{{Point{10, 20}, Point{10, 32}}, {Point{20, 32}, Point{28, 32}}} {
+		found := false
+		for _, textEdge := range shape.Edges {
+			found = found || textEdge == edge
+		}
+		if !found {
+			t.Errorf("Couldn't find edge %v in %v\n", edge, shape.Edges)
+		}
+	}
+	// Case 2: Lower case letters are drawn as upper case ones, and the text may have commas
+	lower, err := ParseSvgText("10,20,12,hi, hi")
+	if err != nil {
+		t.Errorf("Received error %v\n", err)
+	} else if len(lower.Edges) != 13 {
+		t.Errorf("Expected 13 edges, got %d\n", len(lower.Edges))
+	}
+
+	invalid := []string{"10,20,12", "10,20,12,", "10,20,12,   ", "10,20,0,HI", "10,20,-1,HI", "x,20,12,HI", "10,20,12,<HI>",
+		"NaN,NaN,10,HI", "10,20,NaN,HI", "Inf,20,12,HI", "10,-Inf,12,HI", "10,20,+Inf,HI"}
+	for _, s := range invalid {
+		if _, err := ParseSvgText(s); err == nil {
+			t.Errorf("Expected error for %s\n", s)
+		} else if _, ok := err.(InvalidShapeSvgStringError); !ok {
+			t.Errorf("Expected InvalidShapeSvgStringError for %s, got %v\n", s, err)
+		}
+	}
+}
+
+func TestTextShape(t *testing.T) {
+	setUpCanvas(100, 100)
+	// Case 1: Text uses ink for the length of its strokes
+	// Expect 6 + 6 + 4 pixels of ink for H and 4 + 6 + 4 for I, 6 pixels high
+	shape, err := convertShape(TEXT, "0,0,6,HI", TRANSPARENT, "red", OpOptions{}, InkPricing{})
+	if err != nil {
+		t.Errorf("Received error %v\n", err)
+	} else if shape.Ink != 30 {
+		t.Errorf("Expected 30 units of ink, used %d\n", shape.Ink)
+	}
+	// Case 2: Text can't be filled
+	// Expect error
+	if _, err := convertShape(TEXT, "0,0,6,HI", "red", "red", OpOptions{}, InkPricing{}); err == nil {
+		t.Errorf("Expected error for filled text\n")
+	}
+	// Case 3: Text running off the canvas
+	// Expect error
+	if _, err := convertShape(TEXT, "0,0,6,"+strings.Repeat("W", 25), TRANSPARENT, "red", OpOptions{}, InkPricing{}); err == nil {
+		t.Errorf("Expected error for text off the canvas\n")
+	}
+	// Case 4: Text overlaps a shape drawn over it
+	settings := CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}
+	line := Shape{Edges: []Edge{{Point{0, 3}, Point{50, 3}}}}
+	if shape != nil && !ShapesIntersect(*shape, line, settings) {
+		t.Errorf("Expected line through text to intersect it\n")
+	}
+}
@@ -0,0 +1,208 @@
+/*
+
+This file is part of the blockartlib package, and contains the checks done in verifying mode, where the library
+does not trust its miner: it downloads block headers and checks their proof-of-work, signatures and chain
+length itself, and checks that shapes are in the chain with inclusion proofs.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/gob"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// Checks that the hash meets the proof-of-work difficulty: it must end in difficulty zeros.
+// @param hash string: hex encoding of the block hash
+// @param difficulty uint8: the number of trailing zeros required
+// @return bool: true iff the hash meets the difficulty
+func HasProofOfWork(hash string, difficulty uint8) bool {
+	n := int(difficulty)
+	if n > len(hash) {
+		return false
+	}
+	return hash[len(hash)-n:] == strings.Repeat("0", n)
+}
+
+// Checks a single block header on its own: its version, that it hashes to header.Hash, that the hash meets
+// the proof-of-work difficulty, and that it was signed by the miner named in the header.
+// @param header BlockHeader: the header to check
+// @param settings MinerNetSettings: settings of the network the block belongs to
+// @return error: VerificationError if the header is not valid, nil otherwise
+func VerifyBlockHeader(header BlockHeader, settings MinerNetSettings) error {
+	if header.Version != EffectiveBlockVersion(settings.BlockVersion) {
+		return VerificationError(header.Hash)
+	}
+
+	hash := HashBlockHeader(header)
+	if hash.ToString() != header.Hash {
+		return VerificationError(header.Hash)
+	}
+
+	difficulty := settings.PoWDifficultyOpBlock
+	if header.NumOps == 0 {
+		difficulty = settings.PoWDifficultyNoOpBlock
+	}
+	if !HasProofOfWork(header.Hash, difficulty) {
+		return VerificationError(header.Hash)
+	}
+
+	pub, err := hex.DecodeString(header.Miner)
+	if err != nil {
+		return VerificationError(header.Hash)
+	}
+	minerPubKey, err := x509.ParsePKIXPublicKey(pub)
+	if err != nil {
+		return VerificationError(header.Hash)
+	}
+	ecdsaPubKey, ok := minerPubKey.(*ecdsa.PublicKey)
+	if !ok || header.R == nil || header.S == nil || !ecdsa.Verify(ecdsaPubKey, hash, header.R, header.S) {
+		return VerificationError(header.Hash)
+	}
+
+	return nil
+}
+
+// Checks a chain of block headers, oldest first: the first header must follow the genesis block, each
+// header must follow the one before it and be one longer, and each header must be valid on its own.
+// @param headers []BlockHeader: the headers, from the block after the genesis block to the head
+// @param settings MinerNetSettings: settings of the network the chain belongs to
+// @return error: VerificationError naming the first bad header, nil if the chain is valid
+func VerifyHeaderChain(headers []BlockHeader, settings MinerNetSettings) error {
+	return verifyHeadersAfter(settings.GenesisBlockHash, 0, headers, settings)
+}
+
+// Checks a chain of block headers, oldest first, that continues a chain already checked: the first header
+// must follow the block with hash prevHash, whose chain has length prevLen.
+// @param prevHash string: hash of the block the first header must follow
+// @param prevLen int: length of the chain ending at that block
+// @param headers []BlockHeader: the headers, from the block after prevHash onwards
+// @param settings MinerNetSettings: settings of the network the chain belongs to
+// @return error: VerificationError naming the first bad header, nil if the chain is valid
+func verifyHeadersAfter(prevHash string, prevLen int, headers []BlockHeader, settings MinerNetSettings) error {
+	for i, header := range headers {
+		if header.PrevHash != prevHash || header.Len != prevLen+i+1 {
+			return VerificationError(header.Hash)
+		}
+		if err := VerifyBlockHeader(header, settings); err != nil {
+			return err
+		}
+		prevHash = header.Hash
+	}
+	return nil
+}
+
+// The verified headers of a miner's longest chain, kept between calls so that only the headers mined
+// since the last call are downloaded and verified.
+type headerCache struct {
+	lock    sync.Mutex
+	headers []BlockHeader // oldest first
+}
+
+// Brings the cache up to the miner's longest chain. Headers are fetched from the last verified one on, so
+// that a chain that no longer extends it (after a reorganization) is noticed, and is then verified from
+// the genesis block.
+// @param fetch func: fetches a page of the longest chain's headers from a height on, and the chain's length
+// @param settings MinerNetSettings: settings of the network the chain belongs to
+// @return []BlockHeader: the verified headers, oldest first; must not be modified
+// @return error: any error returned by fetch, or VerificationError
+func (cache *headerCache) update(fetch func(from int) ([]BlockHeader, int, error), settings MinerNetSettings) ([]BlockHeader, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for {
+		verified := len(cache.headers)
+		from := verified
+		if from == 0 {
+			from = 1
+		}
+		headers, headLen, err := fetch(from)
+		if err != nil {
+			return nil, err
+		}
+
+		prevHash, prevLen := settings.GenesisBlockHash, 0
+		if verified > 0 {
+			last := cache.headers[verified-1]
+			if len(headers) == 0 || headers[0].Hash != last.Hash {
+				// the longest chain no longer goes through the last verified header
+				cache.headers = nil
+				continue
+			}
+			headers = headers[1:]
+			prevHash, prevLen = last.Hash, last.Len
+		}
+
+		if err := verifyHeadersAfter(prevHash, prevLen, headers, settings); err != nil {
+			return nil, err
+		}
+		cache.headers = append(cache.headers, headers...)
+		if len(headers) == 0 || len(cache.headers) >= headLen {
+			return cache.headers, nil
+		}
+	}
+}
+
+// Brings the canvas's verified headers up to the miner's longest chain.
+// @param canvas CanvasInstance
+// @return []BlockHeader: the verified headers, oldest first
+// @return error: DisconnectedError or VerificationError
+func (canvas CanvasInstance) verifiedHeaderChain() ([]BlockHeader, error) {
+	gob.Register(DisconnectedError(""))
+
+	fetch := func(from int) ([]BlockHeader, int, error) {
+		args := &GetBlockHeadersArgs{From: from}
+		var reply GetBlockHeadersReply
+		if err := canvas.client.Call("LibMin.GetBlockHeadersIM", args, &reply); err != nil {
+			return nil, 0, DisconnectedError(canvas.minerAddr)
+		}
+		return reply.Headers, reply.HeadLen, reply.Error
+	}
+	return canvas.headers.update(fetch, canvas.netSettings)
+}
+
+// Checks the proof against the miner's verified chain of headers. The proof must be for the shape with the
+// passed hash, and its block must be on the chain with at least validateNum blocks after it.
+// @param canvas CanvasInstance
+// @param shapeHash string: hash of the shape the proof must be for
+// @param proof ShapeProof: the proof, as returned by GetShapeProof
+// @param validateNum uint8: the number of blocks that must follow the proof's block
+// @return error: DisconnectedError or VerificationError
+func (canvas CanvasInstance) verifyShapeProof(shapeHash string, proof ShapeProof, validateNum uint8) error {
+	headers, err := canvas.verifiedHeaderChain()
+	if err != nil {
+		return err
+	}
+	return checkShapeProof(shapeHash, proof, headers, validateNum)
+}
+
+// Checks the proof against a verified chain of headers, as verifyShapeProof does.
+// @param shapeHash string: hash of the shape the proof must be for
+// @param proof ShapeProof: the proof
+// @param headers []BlockHeader: the verified headers of the longest chain, oldest first
+// @param validateNum uint8: the number of blocks that must follow the proof's block
+// @return error: VerificationError if the proof does not show the shape is on the chain, nil otherwise
+func checkShapeProof(shapeHash string, proof ShapeProof, headers []BlockHeader, validateNum uint8) error {
+	if proof.ShapeHash != shapeHash {
+		// a genuine proof, but for some other shape
+		return VerificationError(shapeHash)
+	}
+
+	for _, header := range headers {
+		if header.Hash != proof.BlockHash {
+			continue
+		}
+		if len(headers)-header.Len < int(validateNum) || !VerifyShapeProof(proof, header) {
+			return VerificationError(shapeHash)
+		}
+		return nil
+	}
+
+	// the proof's block is not on the chain
+	return VerificationError(shapeHash)
+}
@@ -0,0 +1,189 @@
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"strconv"
+	"testing"
+)
+
+// Mines a chain of numBlocks empty blocks on top of the genesis block in settings.
+func mineHeaderChain(t *testing.T, numBlocks int, settings MinerNetSettings) []BlockHeader {
+	privKey, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	pub, _ := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+
+	headers := []BlockHeader{}
+	prevHash := settings.GenesisBlockHash
+	for i := 1; i <= numBlocks; i++ {
+		header := BlockHeader{
+			Version:    EffectiveBlockVersion(settings.BlockVersion),
+			PrevHash:   prevHash,
+			Len:        i,
+			Miner:      hex.EncodeToString(pub),
+			Timestamp:  int64(i),
+			MerkleRoot: MerkleRoot(settings.BlockVersion, nil),
+		}
+		for nonce := 0; ; nonce++ {
+			header.Nonce = strconv.Itoa(nonce)
+			if HasProofOfWork(HashBlockHeader(header).ToString(), settings.PoWDifficultyNoOpBlock) {
+				break
+			}
+		}
+		hash := HashBlockHeader(header)
+		header.Hash = hash.ToString()
+		r, s, err := ecdsa.Sign(rand.Reader, privKey, hash)
+		if err != nil {
+			t.Fatalf("Error: %v \n", err)
+		}
+		header.R, header.S = r, s
+
+		headers = append(headers, header)
+		prevHash = header.Hash
+	}
+	return headers
+}
+
+func TestVerifyHeaderChain(t *testing.T) {
+	settings := MinerNetSettings{
+		GenesisBlockHash:       "83218ac34c1834c26781fe4bde918ee4",
+		BlockVersion:           BlockVersionSHA256,
+		PoWDifficultyOpBlock:   2,
+		PoWDifficultyNoOpBlock: 2,
+	}
+
+	// TEST happy path case
+	headers := mineHeaderChain(t, 3, settings)
+	if err := VerifyHeaderChain(headers, settings); err != nil {
+		t.Errorf("Error: %v \n", err)
+	}
+
+	// TEST a header that does not hash to its hash
+	tampered := append([]BlockHeader{}, headers...)
+	tampered[1].Timestamp++
+	if err := VerifyHeaderChain(tampered, settings); err == nil {
+		t.Errorf("Expected a VerificationError for a tampered header \n")
+	}
+
+	// TEST a chain that skips a block
+	if err := VerifyHeaderChain([]BlockHeader{headers[0], headers[2]}, settings); err == nil {
+		t.Errorf("Expected a VerificationError for a broken chain \n")
+	}
+
+	// TEST a header signed by someone other than its miner
+	forged := append([]BlockHeader{}, headers...)
+	forged[2].R, forged[2].S = headers[1].R, headers[1].S
+	if err := VerifyHeaderChain(forged, settings); err == nil {
+		t.Errorf("Expected a VerificationError for a forged signature \n")
+	}
+
+	// TEST a header without enough proof-of-work
+	settings.PoWDifficultyNoOpBlock = 8
+	if err := VerifyBlockHeader(headers[0], settings); err == nil && !HasProofOfWork(headers[0].Hash, 8) {
+		t.Errorf("Expected a VerificationError for insufficient proof-of-work \n")
+	}
+}
+
+func TestHeaderCacheUpdate(t *testing.T) {
+	settings := MinerNetSettings{
+		GenesisBlockHash:       "83218ac34c1834c26781fe4bde918ee4",
+		BlockVersion:           BlockVersionSHA256,
+		PoWDifficultyOpBlock:   1,
+		PoWDifficultyNoOpBlock: 1,
+	}
+	chain := mineHeaderChain(t, 5, settings)
+
+	// serves the first headLen headers of chain, two at a time, and records the heights asked for
+	headLen := 3
+	requests := []int{}
+	fetch := func(from int) ([]BlockHeader, int, error) {
+		requests = append(requests, from)
+		to := from + 1
+		if to > headLen {
+			to = headLen
+		}
+		if from > to {
+			return []BlockHeader{}, headLen, nil
+		}
+		return chain[from-1 : to], headLen, nil
+	}
+	cache := &headerCache{}
+
+	// TEST the first update downloads the whole chain, a page at a time
+	headers, err := cache.update(fetch, settings)
+	if err != nil || len(headers) != 3 || headers[2].Hash != chain[2].Hash {
+		t.Errorf("Expected the first 3 headers, got %d headers and error %v \n", len(headers), err)
+	}
+
+	// TEST later updates only download from the last verified header on
+	headLen = 5
+	requests = nil
+	headers, err = cache.update(fetch, settings)
+	if err != nil || len(headers) != 5 {
+		t.Errorf("Expected 5 headers, got %d headers and error %v \n", len(headers), err)
+	}
+	if len(requests) != 2 || requests[0] != 3 || requests[1] != 4 {
+		t.Errorf("Expected requests from heights 3 and 4, got %v \n", requests)
+	}
+
+	// TEST a chain that no longer goes through the last verified header is verified from the genesis block
+	fork := mineHeaderChain(t, 5, settings)
+	chain = fork
+	requests = nil
+	headers, err = cache.update(fetch, settings)
+	if err != nil || len(headers) != 5 || headers[4].Hash != fork[4].Hash {
+		t.Errorf("Expected the 5 headers of the new chain, got %d headers and error %v \n", len(headers), err)
+	}
+	if len(requests) < 2 || requests[1] != 1 {
+		t.Errorf("Expected the new chain to be downloaded from height 1, got requests %v \n", requests)
+	}
+
+	// TEST a tampered header is not added to the cache
+	tampered := append([]BlockHeader{}, mineHeaderChain(t, 6, settings)...)
+	tampered[5].Timestamp++
+	chain, headLen = tampered, 6
+	cache = &headerCache{headers: append([]BlockHeader{}, tampered[:5]...)}
+	if _, err := cache.update(fetch, settings); err == nil {
+		t.Errorf("Expected a VerificationError for a tampered header \n")
+	}
+	if len(cache.headers) != 5 {
+		t.Errorf("Expected the cache to keep its 5 verified headers, got %d \n", len(cache.headers))
+	}
+}
+
+func TestCheckShapeProof(t *testing.T) {
+	version := BlockVersionSHA256
+	content := HashBytes(version, []byte("op"))
+	leaves := []Hash{HashOp(version, "shape a", content), HashOp(version, "shape b", content)}
+	headers := []BlockHeader{
+		{Hash: "block 1", Len: 1, Version: version, MerkleRoot: MerkleRoot(version, leaves)},
+		{Hash: "block 2", Len: 2, Version: version, MerkleRoot: MerkleRoot(version, nil)},
+	}
+	proofFor := func(i int, shapeHash string) ShapeProof {
+		return ShapeProof{ShapeHash: shapeHash, BlockHash: "block 1", ContentHash: content, Steps: MerkleProof(version, leaves, i)}
+	}
+
+	// TEST happy path case
+	if err := checkShapeProof("shape a", proofFor(0, "shape a"), headers, 1); err != nil {
+		t.Errorf("Error: %v \n", err)
+	}
+
+	// TEST a genuine proof for a different shape than the one asked for
+	if _, ok := checkShapeProof("shape a", proofFor(1, "shape b"), headers, 0).(VerificationError); !ok {
+		t.Errorf("Expected a VerificationError for a proof of another shape \n")
+	}
+
+	// TEST a proof whose block has too few blocks after it
+	if _, ok := checkShapeProof("shape a", proofFor(0, "shape a"), headers, 2).(VerificationError); !ok {
+		t.Errorf("Expected a VerificationError for a block without enough blocks after it \n")
+	}
+
+	// TEST a proof for a block that is not on the chain
+	proof := proofFor(0, "shape a")
+	proof.BlockHash = "block 3"
+	if _, ok := checkShapeProof("shape a", proof, headers, 0).(VerificationError); !ok {
+		t.Errorf("Expected a VerificationError for a block not on the chain \n")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+
+This file is part of the blockartlib package, and contains the Merkle tree used to commit a block to its ops,
+along with the inclusion proofs that let an application check that a shape is in a block given only the
+block's header.
+
+*/
+
+package blockartlib
+
+import "bytes"
+
+// Prefixes that separate leaves from interior nodes, so that an interior node can never be passed off as a leaf.
+const (
+	merkleLeafPrefix     byte = 0
+	merkleInteriorPrefix byte = 1
+)
+
+// One step of a Merkle inclusion proof: the hash of the sibling of the current node.
+type MerkleProofStep struct {
+	Sibling Hash
+	// True if the sibling is the left child, i.e. the current node is the right child.
+	SiblingOnLeft bool
+}
+
+// Proof that the op adding a shape is included in a block.
+type ShapeProof struct {
+	ShapeHash string
+	BlockHash string
+	// Hash of the rest of the op's contents; combined with ShapeHash it gives the op's hash.
+	ContentHash Hash
+	// Path from the op's leaf up to the block's Merkle root.
+	Steps []MerkleProofStep
+}
+
+// Returns the hash of an op, given the hash of the shape it adds (empty if it does not add a shape)
+// and the hash of the op's contents. Committing to the shape hash separately lets a ShapeProof
+// be checked without knowing how the miner encodes ops.
+// @param version uint8: the block version, which selects the hashing algorithm
+// @param shapeHash string: hash of the shape the op adds
+// @param contentHash Hash: hash of the op's contents
+// @return Hash: the hash of the op
+func HashOp(version uint8, shapeHash string, contentHash Hash) Hash {
+	return HashBytes(version, append([]byte(shapeHash+"|"), contentHash...))
+}
+
+// Returns the Merkle root of the leaves. A node without a sibling is promoted to the next level unchanged.
+// @param version uint8: the block version, which selects the hashing algorithm
+// @param leaves []Hash: the leaves, in order
+// @return Hash: the Merkle root; the hash of nothing if there are no leaves
+func MerkleRoot(version uint8, leaves []Hash) Hash {
+	if len(leaves) == 0 {
+		return HashBytes(version, []byte{})
+	}
+
+	level := merkleLeaves(version, leaves)
+	for len(level) > 1 {
+		level = merkleParents(version, level)
+	}
+	return level[0]
+}
+
+// Returns the proof that the leaf at index is included under the Merkle root of leaves.
+// @param version uint8: the block version, which selects the hashing algorithm
+// @param leaves []Hash: the leaves, in order
+// @param index int: the index of the leaf being proven
+// @return []MerkleProofStep: the steps from the leaf to the root; nil if index is out of range
+func MerkleProof(version uint8, leaves []Hash, index int) []MerkleProofStep {
+	if index < 0 || index >= len(leaves) {
+		return nil
+	}
+
+	steps := []MerkleProofStep{}
+	level := merkleLeaves(version, leaves)
+	for len(level) > 1 {
+		if index%2 == 1 {
+			steps = append(steps, MerkleProofStep{Sibling: level[index-1], SiblingOnLeft: true})
+		} else if index+1 < len(level) {
+			steps = append(steps, MerkleProofStep{Sibling: level[index+1], SiblingOnLeft: false})
+		}
+		// otherwise the node has no sibling and is promoted unchanged
+
+		level = merkleParents(version, level)
+		index /= 2
+	}
+	return steps
+}
+
+// Checks that leaf is included under root.
+// @param version uint8: the block version, which selects the hashing algorithm
+// @param leaf Hash: the leaf being proven
+// @param steps []MerkleProofStep: the proof, as returned by MerkleProof
+// @param root Hash: the Merkle root
+// @return bool: true iff the proof is valid
+func VerifyMerkleProof(version uint8, leaf Hash, steps []MerkleProofStep, root Hash) bool {
+	curr := merkleNode(version, merkleLeafPrefix, leaf)
+	for _, step := range steps {
+		if step.SiblingOnLeft {
+			curr = merkleNode(version, merkleInteriorPrefix, step.Sibling, curr)
+		} else {
+			curr = merkleNode(version, merkleInteriorPrefix, curr, step.Sibling)
+		}
+	}
+	return bytes.Equal(curr, root)
+}
+
+// Checks that proof shows the shape is in the block described by header.
+// - ASSUMES that header has already been checked to hash to header.Hash (see HashBlockHeader)
+// @param proof ShapeProof: the proof, as returned by Canvas.GetShapeProof
+// @param header BlockHeader: header of the block the shape is claimed to be in
+// @return bool: true iff the proof is valid
+func VerifyShapeProof(proof ShapeProof, header BlockHeader) bool {
+	if proof.BlockHash != header.Hash {
+		return false
+	}
+
+	opHash := HashOp(header.Version, proof.ShapeHash, proof.ContentHash)
+	return VerifyMerkleProof(header.Version, opHash, proof.Steps, header.MerkleRoot)
+}
+
+// Hashes each leaf into the bottom level of the tree.
+func merkleLeaves(version uint8, leaves []Hash) []Hash {
+	level := make([]Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleNode(version, merkleLeafPrefix, leaf)
+	}
+	return level
+}
+
+// Hashes each pair of nodes in level into the level above it.
+func merkleParents(version uint8, level []Hash) []Hash {
+	parents := []Hash{}
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+		} else {
+			parents = append(parents, merkleNode(version, merkleInteriorPrefix, level[i], level[i+1]))
+		}
+	}
+	return parents
+}
+
+// Hashes the prefix followed by each of the children.
+func merkleNode(version uint8, prefix byte, children ...Hash) Hash {
+	data := []byte{prefix}
+	for _, child := range children {
+		data = append(data, child...)
+	}
+	return HashBytes(version, data)
+}
@@ -0,0 +1,72 @@
+package blockartlib
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for _, version := range []uint8{BlockVersionMD5, BlockVersionSHA256} {
+		for numLeaves := 1; numLeaves <= 7; numLeaves++ {
+			leaves := []Hash{}
+			for i := 0; i < numLeaves; i++ {
+				leaves = append(leaves, HashBytes(version, []byte(fmt.Sprintf("op %d", i))))
+			}
+			root := MerkleRoot(version, leaves)
+
+			for i, leaf := range leaves {
+				proof := MerkleProof(version, leaves, i)
+				if !VerifyMerkleProof(version, leaf, proof, root) {
+					t.Errorf("Expected proof of leaf %d of %d to verify \n", i, numLeaves)
+				}
+				// the proof must not verify a different leaf
+				other := leaves[(i+1)%numLeaves]
+				if numLeaves > 1 && VerifyMerkleProof(version, other, proof, root) {
+					t.Errorf("Expected proof of leaf %d of %d not to verify leaf %d \n", i, numLeaves, (i+1)%numLeaves)
+				}
+			}
+		}
+	}
+
+	// TEST out of range index
+	leaves := []Hash{HashBytes(BlockVersionSHA256, []byte("op"))}
+	if MerkleProof(BlockVersionSHA256, leaves, 1) != nil {
+		t.Errorf("Expected no proof for an out of range index \n")
+	}
+}
+
+func TestVerifyShapeProof(t *testing.T) {
+	version := BlockVersionSHA256
+	contentHashes := []Hash{HashBytes(version, []byte("a")), HashBytes(version, []byte("b")), HashBytes(version, []byte("c"))}
+	shapeHashes := []string{"shape0", "", "shape2"}
+	leaves := []Hash{}
+	for i := range contentHashes {
+		leaves = append(leaves, HashOp(version, shapeHashes[i], contentHashes[i]))
+	}
+
+	header := BlockHeader{Version: version, PrevHash: "00", Len: 1, NumOps: len(leaves), MerkleRoot: MerkleRoot(version, leaves)}
+	header.Hash = HashBlockHeader(header).ToString()
+
+	proof := ShapeProof{
+		ShapeHash:   "shape2",
+		BlockHash:   header.Hash,
+		ContentHash: contentHashes[2],
+		Steps:       MerkleProof(version, leaves, 2),
+	}
+	if !VerifyShapeProof(proof, header) {
+		t.Errorf("Expected shape proof to verify \n")
+	}
+
+	// TEST proof for a different shape
+	proof.ShapeHash = "shape0"
+	if VerifyShapeProof(proof, header) {
+		t.Errorf("Expected proof for the wrong shape not to verify \n")
+	}
+
+	// TEST proof against a different block
+	proof.ShapeHash = "shape2"
+	proof.BlockHash = "00"
+	if VerifyShapeProof(proof, header) {
+		t.Errorf("Expected proof against the wrong block not to verify \n")
+	}
+}
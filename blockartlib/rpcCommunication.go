@@ -13,6 +13,8 @@ type AddShapeArgs struct {
 	// ink-miner only sees internal representation of shapes, conversion is all done by blockartlib before RPC call
 	ShapeMeta   ShapeMeta
 	ValidateNum uint8
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
 }
 
 type AddShapeReply struct {
@@ -25,6 +27,25 @@ type AddShapeReply struct {
 	Error error
 }
 
+type UpdateShapeArgs struct {
+	// Hash of the shape being replaced
+	ShapeHash   string
+	ShapeMeta   ShapeMeta
+	ValidateNum uint8
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
+}
+
+type UpdateShapeReply struct {
+	OpHash       string
+	BlockHash    string
+	InkRemaining uint32
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
 type GetSvgStringArgs struct {
 	OpHash string
 }
@@ -40,15 +61,33 @@ type GetSvgStringReply struct {
 type OpenCanvasArgs struct {
 	Priv ecdsa.PrivateKey
 	Pub  ecdsa.PublicKey
+	// Canvas the app works on; empty for the network's own canvas
+	CanvasID string
 }
 
 type OpenCanvasReply struct {
 	CanvasSettings CanvasSettings
+	// Block version of the network, which determines how shapes are hashed
+	BlockVersion uint8
+
+	// Needed to verify block headers in verifying mode
+	GenesisBlockHash       string
+	PoWDifficultyOpBlock   uint8
+	PoWDifficultyNoOpBlock uint8
+
+	// Needed to compute the ink shapes use
+	InkPricing InkPricing
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
 }
 
 type DeleteShapeArgs struct {
 	ValidateNum uint8
 	ShapeHash   string
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
 }
 
 type DeleteShapeReply struct {
@@ -59,6 +98,137 @@ type DeleteShapeReply struct {
 	Error error
 }
 
+type TransferInkArgs struct {
+	// Public key of the recipient, in the same encoding the miners use for their own keys
+	To          string
+	Amount      uint32
+	ValidateNum uint8
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
+}
+
+type TransferInkReply struct {
+	InkRemaining uint32
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type TransferShapeArgs struct {
+	ShapeHash string
+	// Public key of the recipient, in the same encoding the miners use for their own keys
+	To          string
+	ValidateNum uint8
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
+}
+
+type TransferShapeReply struct {
+	InkRemaining uint32
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type ClaimRegionArgs struct {
+	// Canvas the region is on; empty for the network's own canvas
+	CanvasID string
+	Region   Box
+	// Number of blocks the claim lasts for
+	Blocks uint32
+	// Ink held until the claim expires
+	Deposit     uint32
+	ValidateNum uint8
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
+}
+
+type ClaimRegionReply struct {
+	ClaimHash    string
+	InkRemaining uint32
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type GrantRegionArgs struct {
+	ClaimHash string
+	// Public key of the grantee, in the same encoding the miners use for their own keys
+	To          string
+	ValidateNum uint8
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
+}
+
+type GrantRegionReply struct {
+	InkRemaining uint32
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type CreateCanvasArgs struct {
+	CanvasID    string
+	Settings    CanvasSettings
+	Rules       CanvasRules
+	ValidateNum uint8
+	// Ink paid to the miner of the block that includes the op
+	Fee uint32
+}
+
+type CreateCanvasReply struct {
+	InkRemaining uint32
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type ValidateShapeArgs struct {
+	// the shape, converted by blockartlib as for AddShapeArgs
+	ShapeMeta ShapeMeta
+	// Ink an op adding the shape would pay to the miner of the block that includes it
+	Fee uint32
+}
+
+type ValidateShapeReply struct {
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates the miner would accept the shape
+	Error error
+}
+
+type FindFreeSpaceArgs struct {
+	// the canvas searched, and the layer on it
+	CanvasID string
+	Layer    uint8
+	// size of the box to find space for
+	Width  float64
+	Height float64
+	// most positions to return
+	MaxResults int
+}
+
+type FindFreeSpaceReply struct {
+	// top left corners of the boxes found
+	Positions []Point
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type GetShapeInfoReply struct {
+	Info ShapeInfo
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
 type GetShapesReply struct {
 	ShapeHashes []string
 
@@ -74,3 +244,35 @@ type GetChildrenReply struct {
 	// So, store actual error here; nil indicates no error
 	Error error
 }
+
+type GetBlockHeaderReply struct {
+	Header BlockHeader
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type GetShapeProofReply struct {
+	Proof ShapeProof
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
+
+type GetBlockHeadersArgs struct {
+	// Length of the chain ending at the first header to return; 1 for the block after the genesis block
+	From int
+}
+
+type GetBlockHeadersReply struct {
+	// Headers of the longest chain from From on, oldest first; there may be more than are returned
+	Headers []BlockHeader
+	// Length of the longest chain
+	HeadLen int
+
+	// RPC errors are all cast to a ServerError
+	// So, store actual error here; nil indicates no error
+	Error error
+}
@@ -0,0 +1,186 @@
+/*
+
+This file is part of the blockartlib package, and contains the parser for the transforms that move, rotate
+and scale shapes, and their application to shapes.
+
+*/
+
+package blockartlib
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An affine transform of the canvas, as the svg transform matrix(A B C D E F): it moves the point (x, y)
+// to (A*x + C*y + E, B*x + D*y + F).
+type Transform struct {
+	A, B, C, D, E, F float64
+}
+
+// The transform that leaves every point where it is.
+var IdentityTransform = Transform{A: 1, D: 1}
+
+// Moves a point by the transform.
+// Every product is converted to float64 before it is added, so that it is rounded on its own: Go may
+// otherwise fuse a multiply and an add into one instruction on some architectures, and miners there would
+// get other edges, and so other shape hashes, than the rest of the network.
+// @param p Point
+// @return Point
+func (t Transform) Apply(p Point) Point {
+	return Point{
+		X: float64(t.A*p.X) + float64(t.C*p.Y) + t.E,
+		Y: float64(t.B*p.X) + float64(t.D*p.Y) + t.F,
+	}
+}
+
+// Returns the transform that moves points by t, then by next.
+// As in Apply, every product is rounded on its own.
+// @param next Transform
+// @return Transform
+func (t Transform) Then(next Transform) Transform {
+	return Transform{
+		A: float64(next.A*t.A) + float64(next.C*t.B),
+		B: float64(next.B*t.A) + float64(next.D*t.B),
+		C: float64(next.A*t.C) + float64(next.C*t.D),
+		D: float64(next.B*t.C) + float64(next.D*t.D),
+		E: float64(next.A*t.E) + float64(next.C*t.F) + next.E,
+		F: float64(next.B*t.E) + float64(next.D*t.F) + next.F,
+	}
+}
+
+// Returns the transform that moves points by tx to the right and ty down.
+func Translate(tx float64, ty float64) Transform {
+	return Transform{A: 1, D: 1, E: tx, F: ty}
+}
+
+// Returns the transform that rotates points by the passed number of degrees around the origin, clockwise
+// on the canvas as in svg. Rotations by multiples of 90 degrees are exact.
+func Rotate(degrees float64) Transform {
+	var sin, cos float64
+	switch math.Mod(degrees, 360) {
+	case 0:
+		sin, cos = 0, 1
+	case 90, -270:
+		sin, cos = 1, 0
+	case 180, -180:
+		sin, cos = 0, -1
+	case 270, -90:
+		sin, cos = -1, 0
+	default:
+		sin, cos = math.Sincos(degrees * math.Pi / 180)
+	}
+	return Transform{A: cos, B: sin, C: -sin, D: cos}
+}
+
+// Returns the transform that scales points away from the origin by sx horizontally and sy vertically.
+func Scale(sx float64, sy float64) Transform {
+	return Transform{A: sx, D: sy}
+}
+
+// Parses a transform. Transforms follow the grammar of the svg transform attribute:
+//
+//	transform = "" | item | item spaces transform
+//	item      = name spaces "(" spaces numbers spaces ")"
+//	numbers   = number | number separator numbers
+//	separator = spaces "," spaces | " " spaces
+//
+// where name and its numbers are one of translate(tx [ty]), rotate(degrees [cx cy]), scale(sx [sy]) and
+// matrix(a b c d e f). As in svg, the items apply from last to first, so "translate(10 0) rotate(90)"
+// rotates a shape, then moves it. The empty transform leaves shapes as they are.
+// @param s string: the transform
+// @return Transform
+// @return error: InvalidTransformError iff s is not a transform
+func ParseTransform(s string) (Transform, error) {
+	transform := IdentityTransform
+	rest := strings.TrimLeft(s, " ")
+	for rest != "" {
+		open := strings.Index(rest, "(")
+		close := strings.Index(rest, ")")
+		if open < 0 || close < open {
+			return Transform{}, InvalidTransformError(s)
+		}
+		name := strings.TrimRight(rest[:open], " ")
+		args, ok := parseTransformArgs(rest[open+1 : close])
+		if !ok {
+			return Transform{}, InvalidTransformError(s)
+		}
+
+		var item Transform
+		switch {
+		case name == "translate" && len(args) == 1:
+			item = Translate(args[0], 0)
+		case name == "translate" && len(args) == 2:
+			item = Translate(args[0], args[1])
+		case name == "rotate" && len(args) == 1:
+			item = Rotate(args[0])
+		case name == "rotate" && len(args) == 3:
+			// rotate around (cx, cy): move it to the origin, rotate, and move it back
+			item = Translate(-args[1], -args[2]).Then(Rotate(args[0])).Then(Translate(args[1], args[2]))
+		case name == "scale" && len(args) == 1:
+			item = Scale(args[0], args[0])
+		case name == "scale" && len(args) == 2:
+			item = Scale(args[0], args[1])
+		case name == "matrix" && len(args) == 6:
+			item = Transform{args[0], args[1], args[2], args[3], args[4], args[5]}
+		default:
+			return Transform{}, InvalidTransformError(s)
+		}
+		// items further right apply first
+		transform = item.Then(transform)
+		rest = strings.TrimLeft(rest[close+1:], " ")
+	}
+	return transform, nil
+}
+
+// Parses the numbers of an item of a transform, separated by spaces or a comma.
+// @param s string: the text between the item's brackets
+// @return []float64: the numbers
+// @return bool: false iff s is not a list of numbers
+func parseTransformArgs(s string) ([]float64, bool) {
+	var args []float64
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.FieldsFunc(part, func(r rune) bool { return r == ' ' })
+		if len(fields) == 0 {
+			return nil, false
+		}
+		for _, field := range fields {
+			arg, err := strconv.ParseFloat(field, 64)
+			if err != nil || math.IsInf(arg, 0) || math.IsNaN(arg) {
+				return nil, false
+			}
+			args = append(args, arg)
+		}
+	}
+	return args, true
+}
+
+// Moves the shape by its Transform: the ends of its edges, or the centre and radius of a circle. Circles
+// stay circles only under transforms that scale both directions alike, so no others are accepted for them.
+// @param shape *Shape: the shape, as drawn by its svg string
+// @return error: InvalidTransformError iff shape.Transform is not a transform, or one the shape can't take
+func ApplyTransform(shape *Shape) error {
+	transform, err := ParseTransform(shape.Transform)
+	if err != nil {
+		return err
+	}
+	if transform == IdentityTransform {
+		return nil
+	}
+	if shape.IsCircle {
+		rotatesAndScales := transform.A == transform.D && transform.B == -transform.C
+		reflectsAndScales := transform.A == -transform.D && transform.B == transform.C
+		if !rotatesAndScales && !reflectsAndScales {
+			return InvalidTransformError(shape.Transform)
+		}
+		centre := transform.Apply(Point{X: shape.Cx, Y: shape.Cy})
+		shape.Cx, shape.Cy = centre.X, centre.Y
+		shape.Radius *= math.Hypot(transform.A, transform.B)
+		return nil
+	}
+	for i, edge := range shape.Edges {
+		shape.Edges[i] = Edge{Start: transform.Apply(edge.Start), End: transform.Apply(edge.End)}
+	}
+	return nil
+}
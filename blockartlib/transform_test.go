@@ -0,0 +1,89 @@
+package blockartlib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTransform(t *testing.T) {
+	valid := map[string]Transform{
+		"":                           IdentityTransform,
+		"translate(10)":              {1, 0, 0, 1, 10, 0},
+		"translate(10, 20)":          {1, 0, 0, 1, 10, 20},
+		"translate( 10 20 )":         {1, 0, 0, 1, 10, 20},
+		"rotate(90)":                 {0, 1, -1, 0, 0, 0},
+		"rotate(-90)":                {0, -1, 1, 0, 0, 0},
+		"rotate(180 10 10)":          {-1, 0, 0, -1, 20, 20},
+		"scale(2)":                   {2, 0, 0, 2, 0, 0},
+		"scale(2 3)":                 {2, 0, 0, 3, 0, 0},
+		"matrix(1 2 3 4 5 6)":        {1, 2, 3, 4, 5, 6},
+		"translate(10 0) rotate(90)": {0, 1, -1, 0, 10, 0},
+		"rotate(90) translate(10 0)": {0, 1, -1, 0, 0, 10},
+		"translate(1,2) scale(2) ":   {2, 0, 0, 2, 1, 2},
+	}
+	for s, expected := range valid {
+		transform, err := ParseTransform(s)
+		if err != nil {
+			t.Errorf("Received error %v for %s\n", err, s)
+			continue
+		}
+		if transform != expected {
+			t.Errorf("Expected %v for %s, got %v\n", expected, s, transform)
+		}
+	}
+
+	invalid := []string{"translate", "translate()", "translate(1 2 3)", "rotate(1 2)", "skewX(10)", "scale(1,,2)",
+		"scale(,1)", "matrix(1 2 3 4 5)", "translate(NaN)", "translate(1) junk", "translate(1\" onload=\"alert(1))"}
+	for _, s := range invalid {
+		transform, err := ParseTransform(s)
+		if err == nil {
+			t.Errorf("Expected error for %s, got %v\n", s, transform)
+		} else if _, ok := err.(InvalidTransformError); !ok {
+			t.Errorf("Expected InvalidTransformError for %s, got %v\n", s, err)
+		}
+	}
+}
+
+func TestApplyTransform(t *testing.T) {
+	// Case 1: Path drawn around the origin, moved onto the canvas
+	setUpCanvas(100, 100)
+	shape, err := svgToShape("M -5 -5 h 10 v 10 h -10 z", "translate(50 50)")
+	if err != nil {
+		t.Errorf("Received error %v\n", err)
+	} else {
+		for _, edge := range shape.Edges {
+			if edge.Start.X < 45 || edge.Start.X > 55 || edge.Start.Y < 45 || edge.Start.Y > 55 {
+				t.Errorf("Expected edge %v to be moved around (50, 50)\n", edge)
+			}
+		}
+	}
+	// Case 2: The same path without the transform is off the canvas
+	// Expect error
+	if _, err := svgToShape("M -5 -5 h 10 v 10 h -10 z", ""); err == nil {
+		t.Errorf("Expected error for path off the canvas\n")
+	}
+	// Case 3: Circle scaled alike in both directions
+	circle, err := svgToCircleShape("10,10,5", "scale(2)")
+	if err != nil {
+		t.Errorf("Received error %v\n", err)
+	} else if circle.Cx != 20 || circle.Cy != 20 || circle.Radius != 10 {
+		t.Errorf("Expected circle at (20, 20) of radius 10, got %v\n", circle)
+	}
+	// Case 4: Circle scaled differently in each direction would be an ellipse
+	// Expect error
+	if _, err := svgToCircleShape("10,10,5", "scale(2 3)"); err == nil {
+		t.Errorf("Expected error for circle scaled into an ellipse\n")
+	}
+	// Case 5: Rotation followed by a matrix moves edges to exactly the same points on every architecture,
+	// as each product is rounded on its own
+	shape, err = svgToShape("M 10 0 L 0 10 L 7 3", "rotate(30) matrix(0.5 0.25 -0.25 0.5 40 40)")
+	expected := Edges{
+		{Start: Point{X: 17.721143170299747, Y: 59.30607966083864}, End: Point{X: 9.975952641916457, Y: 57.72114317029973}},
+		{Start: Point{X: 9.975952641916457, Y: 57.72114317029973}, End: Point{X: 15.39758601178476, Y: 58.83059871367697}},
+	}
+	if err != nil {
+		t.Errorf("Received error %v\n", err)
+	} else if !reflect.DeepEqual(shape.Edges, expected) {
+		t.Errorf("Expected edges %v, got %v\n", expected, shape.Edges)
+	}
+}
@@ -3,22 +3,29 @@ package main
 import (
 	"./blockartlib"
 	"bufio"
+	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("Usage: go run cli.go [miner ip:port] [privKey]")
+	if len(os.Args) != 3 && len(os.Args) != 4 {
+		fmt.Println("Usage: go run cli.go [miner ip:port] [privKey] [canvasID (optional)]")
 		os.Exit(1)
 	}
 
 	minerAddr := os.Args[1]
 	privKeyArg := os.Args[2]
+	canvasID := ""
+	if len(os.Args) == 4 {
+		canvasID = os.Args[3]
+	}
 
 	privKeyStr, err := hex.DecodeString(privKeyArg)
 	if err != nil {
@@ -30,7 +37,7 @@ func main() {
 	}
 	privKey := *privKeyParsed
 
-	canvas, settings, err := blockartlib.OpenCanvas(minerAddr, privKey)
+	canvas, settings, err := blockartlib.OpenCanvasWithID(minerAddr, privKey, canvasID)
 	if err != nil {
 		fmt.Println("Failure opening canvas")
 		panic(err)
@@ -43,13 +50,24 @@ func main() {
 	fmt.Printf("\tCanvas Height: %d\n\n", settings.CanvasYMax)
 	fmt.Println("Commands:")
 	// No cirlce for now, default to path.
-	fmt.Println("\tAddShape [validateNum] [svgString] [fill] [stroke] [PATH | CIRCLE]")
+	fmt.Println("\tAddShape [validateNum] [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
+	fmt.Println("\tUpdateShape [validateNum] [shapeHash] [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
+	fmt.Println("\tValidateShape [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
+	fmt.Println("\tFindFreeSpace [width] [height] [maxResults]")
 	fmt.Println("\tGetSvgString [shapeHash]")
 	fmt.Println("\tGetInk")
 	fmt.Println("\tDeleteShape [validateNum] [shapeHash]")
+	fmt.Println("\tTransferInk [validateNum] [toPubKey] [amount]")
+	fmt.Println("\tTransferShape [validateNum] [shapeHash] [toPubKey]")
+	fmt.Println("\tGetShapeInfo [shapeHash]")
+	fmt.Println("\tClaimRegion [validateNum] [minX] [minY] [maxX] [maxY] [blocks] [deposit]")
+	fmt.Println("\tGrantRegion [validateNum] [claimHash] [toPubKey]")
+	fmt.Println("\tCreateCanvas [validateNum] [canvasID] [width] [height] [creatorOnly] [maxShapeInk] [maxLayer]")
 	fmt.Println("\tGetShapes [blockHash]")
 	fmt.Println("\tGetGensisBlock")
 	fmt.Println("\tGetChildren [blockHash]")
+	fmt.Println("\tGetBlockHeader [blockHash]")
+	fmt.Println("\tVerifyShape [shapeHash]")
 	fmt.Println("\tCloseCanvas")
 	fmt.Println("\tExit")
 
@@ -69,7 +87,7 @@ func main() {
 			if len(words) < 7 {
 				fmt.Println("Bad args")
 				fmt.Println("AddShapeUsage:")
-				fmt.Println("\tAddShape [validateNum] [svgString] [fill] [stroke] [PATH | CIRCLE]")
+				fmt.Println("\tAddShape [validateNum] [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
 				continue
 			}
 
@@ -79,7 +97,7 @@ func main() {
 			stroke := words[len(words) - 2]
 			shapeTypeArg := words[len(words) - 1]
 
-			if err != nil || (shapeTypeArg != "PATH" && shapeTypeArg != "CIRCLE") {
+			if err != nil || (shapeTypeArg != "PATH" && shapeTypeArg != "CIRCLE" && shapeTypeArg != "TEXT") {
 				fmt.Println("Bad args")
 				fmt.Println("AddShapeUsage:")
 				fmt.Println("\tAddShape [validateNum] [svgString] [fill] [stroke]")
@@ -89,8 +107,10 @@ func main() {
 			var shapeType blockartlib.ShapeType
 			if shapeTypeArg == "PATH" {
 				shapeType = blockartlib.ShapeType(blockartlib.PATH)
-			} else {
+			} else if shapeTypeArg == "CIRCLE" {
 				shapeType = blockartlib.ShapeType(blockartlib.CIRCLE)
+			} else {
+				shapeType = blockartlib.ShapeType(blockartlib.TEXT)
 			}
 
 			shapeHash, blockHash, inkRemaining, err := canvas.AddShape(uint8(validateNum), shapeType, svgString, fill, stroke)
@@ -104,6 +124,115 @@ func main() {
 			fmt.Printf("shapeHash: %s\n", shapeHash)
 			fmt.Printf("blockHash: %s\n", blockHash)
 			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+		case "UpdateShape":
+			if len(words) < 8 {
+				fmt.Println("Bad args")
+				fmt.Println("UpdateShapeUsage:")
+				fmt.Println("\tUpdateShape [validateNum] [shapeHash] [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
+				continue
+			}
+
+			validateNum, err := strconv.Atoi(words[1])
+			oldShapeHash := words[2]
+			svgString := strings.Join(words[3:len(words)-3], " ")
+			fill := words[len(words)-3]
+			stroke := words[len(words)-2]
+			shapeTypeArg := words[len(words)-1]
+
+			if err != nil || (shapeTypeArg != "PATH" && shapeTypeArg != "CIRCLE" && shapeTypeArg != "TEXT") {
+				fmt.Println("Bad args")
+				fmt.Println("UpdateShapeUsage:")
+				fmt.Println("\tUpdateShape [validateNum] [shapeHash] [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
+				continue
+			}
+
+			var shapeType blockartlib.ShapeType
+			if shapeTypeArg == "PATH" {
+				shapeType = blockartlib.ShapeType(blockartlib.PATH)
+			} else if shapeTypeArg == "CIRCLE" {
+				shapeType = blockartlib.ShapeType(blockartlib.CIRCLE)
+			} else {
+				shapeType = blockartlib.ShapeType(blockartlib.TEXT)
+			}
+
+			shapeHash, blockHash, inkRemaining, err := canvas.UpdateShape(uint8(validateNum), oldShapeHash, shapeType, svgString, fill, stroke)
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("shapeHash: %s\n", shapeHash)
+			fmt.Printf("blockHash: %s\n", blockHash)
+			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+		case "ValidateShape":
+			if len(words) < 5 {
+				fmt.Println("Bad args")
+				fmt.Println("ValidateShapeUsage:")
+				fmt.Println("\tValidateShape [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
+				continue
+			}
+
+			svgString := strings.Join(words[1:len(words)-3], " ")
+			fill := words[len(words)-3]
+			stroke := words[len(words)-2]
+			shapeTypeArg := words[len(words)-1]
+
+			var shapeType blockartlib.ShapeType
+			if shapeTypeArg == "PATH" {
+				shapeType = blockartlib.ShapeType(blockartlib.PATH)
+			} else if shapeTypeArg == "CIRCLE" {
+				shapeType = blockartlib.ShapeType(blockartlib.CIRCLE)
+			} else if shapeTypeArg == "TEXT" {
+				shapeType = blockartlib.ShapeType(blockartlib.TEXT)
+			} else {
+				fmt.Println("Bad args")
+				fmt.Println("ValidateShapeUsage:")
+				fmt.Println("\tValidateShape [svgString] [fill] [stroke] [PATH | CIRCLE | TEXT]")
+				continue
+			}
+
+			ink, bounds, err := canvas.ValidateShape(shapeType, svgString, fill, stroke)
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("ink: %d\n", ink)
+			fmt.Printf("bounds: (%v, %v) to (%v, %v)\n", bounds.MinX, bounds.MinY, bounds.MaxX, bounds.MaxY)
+		case "FindFreeSpace":
+			if len(words) != 4 {
+				fmt.Println("Bad args")
+				fmt.Println("FindFreeSpaceUsage:")
+				fmt.Println("\tFindFreeSpace [width] [height] [maxResults]")
+				continue
+			}
+
+			width, errWidth := strconv.ParseFloat(words[1], 64)
+			height, errHeight := strconv.ParseFloat(words[2], 64)
+			maxResults, errMax := strconv.Atoi(words[3])
+			if errWidth != nil || errHeight != nil || errMax != nil {
+				fmt.Println("Bad args")
+				fmt.Println("FindFreeSpaceUsage:")
+				fmt.Println("\tFindFreeSpace [width] [height] [maxResults]")
+				continue
+			}
+
+			positions, err := canvas.FindFreeSpace(width, height, maxResults)
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("found %d free positions\n", len(positions))
+			for _, position := range positions {
+				fmt.Printf("(%v, %v)\n", position.X, position.Y)
+			}
 		case "GetSvgString":
 			if len(words) != 2 {
 				fmt.Println("Bad args")
@@ -167,6 +296,173 @@ func main() {
 			}
 
 			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+		case "TransferInk":
+			if len(words) != 4 {
+				fmt.Println("Bad args")
+				fmt.Println("TransferInk Usage")
+				fmt.Println("\tTransferInk [validateNum] [toPubKey] [amount]")
+				continue
+			}
+
+			validateNum, err := strconv.Atoi(words[1])
+			amount, amountErr := strconv.ParseUint(words[3], 10, 32)
+			toPubKey, pubErr := parsePubKey(words[2])
+			if err != nil || amountErr != nil || pubErr != nil {
+				fmt.Println("Bad args")
+				fmt.Println("TransferInk Usage")
+				fmt.Println("\tTransferInk [validateNum] [toPubKey] [amount]")
+				continue
+			}
+
+			inkRemaining, err := canvas.TransferInk(*toPubKey, uint32(amount), uint8(validateNum))
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+		case "TransferShape":
+			if len(words) != 4 {
+				fmt.Println("Bad args")
+				fmt.Println("TransferShape Usage")
+				fmt.Println("\tTransferShape [validateNum] [shapeHash] [toPubKey]")
+				continue
+			}
+
+			validateNum, err := strconv.Atoi(words[1])
+			shapeHash := words[2]
+			toPubKey, pubErr := parsePubKey(words[3])
+			if err != nil || pubErr != nil {
+				fmt.Println("Bad args")
+				fmt.Println("TransferShape Usage")
+				fmt.Println("\tTransferShape [validateNum] [shapeHash] [toPubKey]")
+				continue
+			}
+
+			inkRemaining, err := canvas.TransferShape(shapeHash, *toPubKey, uint8(validateNum))
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+		case "ClaimRegion":
+			if len(words) != 8 {
+				fmt.Println("Bad args")
+				fmt.Println("ClaimRegion Usage")
+				fmt.Println("\tClaimRegion [validateNum] [minX] [minY] [maxX] [maxY] [blocks] [deposit]")
+				continue
+			}
+
+			validateNum, err := strconv.Atoi(words[1])
+			var region blockartlib.Box
+			var coordErr error
+			for i, coord := range []*float64{&region.MinX, &region.MinY, &region.MaxX, &region.MaxY} {
+				if *coord, coordErr = strconv.ParseFloat(words[2+i], 64); coordErr != nil {
+					break
+				}
+			}
+			blocks, blocksErr := strconv.ParseUint(words[6], 10, 32)
+			deposit, depositErr := strconv.ParseUint(words[7], 10, 32)
+			if err != nil || coordErr != nil || blocksErr != nil || depositErr != nil {
+				fmt.Println("Bad args")
+				fmt.Println("ClaimRegion Usage")
+				fmt.Println("\tClaimRegion [validateNum] [minX] [minY] [maxX] [maxY] [blocks] [deposit]")
+				continue
+			}
+
+			claimHash, inkRemaining, err := canvas.ClaimRegion(region, uint32(blocks), uint32(deposit), uint8(validateNum))
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("claimHash: %s\n", claimHash)
+			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+		case "GrantRegion":
+			if len(words) != 4 {
+				fmt.Println("Bad args")
+				fmt.Println("GrantRegion Usage")
+				fmt.Println("\tGrantRegion [validateNum] [claimHash] [toPubKey]")
+				continue
+			}
+
+			validateNum, err := strconv.Atoi(words[1])
+			claimHash := words[2]
+			toPubKey, pubErr := parsePubKey(words[3])
+			if err != nil || pubErr != nil {
+				fmt.Println("Bad args")
+				fmt.Println("GrantRegion Usage")
+				fmt.Println("\tGrantRegion [validateNum] [claimHash] [toPubKey]")
+				continue
+			}
+
+			inkRemaining, err := canvas.GrantRegion(claimHash, *toPubKey, uint8(validateNum))
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+		case "CreateCanvas":
+			if len(words) != 8 {
+				fmt.Println("Bad args")
+				fmt.Println("CreateCanvas Usage")
+				fmt.Println("\tCreateCanvas [validateNum] [canvasID] [width] [height] [creatorOnly] [maxShapeInk] [maxLayer]")
+				continue
+			}
+
+			validateNum, err := strconv.Atoi(words[1])
+			width, widthErr := strconv.ParseUint(words[3], 10, 32)
+			height, heightErr := strconv.ParseUint(words[4], 10, 32)
+			creatorOnly, creatorErr := strconv.ParseBool(words[5])
+			maxShapeInk, inkErr := strconv.ParseUint(words[6], 10, 32)
+			maxLayer, layerErr := strconv.ParseUint(words[7], 10, 8)
+			if err != nil || widthErr != nil || heightErr != nil || creatorErr != nil || inkErr != nil || layerErr != nil {
+				fmt.Println("Bad args")
+				fmt.Println("CreateCanvas Usage")
+				fmt.Println("\tCreateCanvas [validateNum] [canvasID] [width] [height] [creatorOnly] [maxShapeInk] [maxLayer]")
+				continue
+			}
+
+			newSettings := blockartlib.CanvasSettings{CanvasXMax: uint32(width), CanvasYMax: uint32(height)}
+			rules := blockartlib.CanvasRules{CreatorOnly: creatorOnly, MaxShapeInk: uint32(maxShapeInk),
+				MaxLayer: uint8(maxLayer)}
+			inkRemaining, err := canvas.CreateCanvas(words[2], newSettings, rules, uint8(validateNum))
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("inkRemaining: %d\n", inkRemaining)
+			if len(words) != 2 {
+				fmt.Println("Bad args")
+				fmt.Println("GetShapeInfo Usage:")
+				fmt.Println("\tGetShapeInfo [shapeHash]")
+				continue
+			}
+
+			info, err := canvas.GetShapeInfo(words[1])
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("owner: %s\n", info.Owner)
+			fmt.Printf("ink: %d\n", info.Ink)
+			fmt.Printf("canvas: %s\n", info.Canvas)
 		case "GetShapes":
 			if len(words) != 2 {
 				fmt.Println("Bad args")
@@ -220,6 +516,52 @@ func main() {
 			}
 
 			fmt.Printf("blockHashes: %v\n", blockHashes)
+		case "GetBlockHeader":
+			if len(words) != 2 {
+				fmt.Println("Bad args")
+				fmt.Println("GetBlockHeader Usage:")
+				fmt.Println("\tGetBlockHeader [blockHash]")
+				continue
+			}
+
+			blockHash := words[1]
+
+			header, err := canvas.GetBlockHeader(blockHash)
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("version: %d\n", header.Version)
+			fmt.Printf("prevHash: %s\n", header.PrevHash)
+			fmt.Printf("len: %d\n", header.Len)
+			fmt.Printf("miner: %s\n", header.Miner)
+			fmt.Printf("nonce: %s\n", header.Nonce)
+			fmt.Printf("timestamp: %s\n", time.Unix(0, header.Timestamp))
+			fmt.Printf("numOps: %d\n", header.NumOps)
+			fmt.Printf("merkleRoot: %s\n", header.MerkleRoot.ToString())
+			fmt.Printf("stateRoot: %s\n", header.StateRoot.ToString())
+		case "VerifyShape":
+			if len(words) != 2 {
+				fmt.Println("Bad args")
+				fmt.Println("VerifyShape Usage:")
+				fmt.Println("\tVerifyShape [shapeHash]")
+				continue
+			}
+
+			shapeHash := words[1]
+
+			blockHash, err := canvas.VerifyShape(shapeHash)
+			if err != nil {
+				fmt.Println("========== ERROR ==========")
+				fmt.Println(err)
+				fmt.Println("==========  END  ==========")
+				continue
+			}
+
+			fmt.Printf("verified in blockHash: %s\n", blockHash)
 		case "CloseCanvas":
 			if len(words) != 1 {
 				fmt.Println("Bad args")
@@ -243,3 +585,20 @@ func main() {
 		}
 	}
 }
+
+// Parses a public key in the hex encoding miners use for their keys
+func parsePubKey(s string) (*ecdsa.PublicKey, error) {
+	pub, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ecdsa public key")
+	}
+	return ecdsaKey, nil
+}
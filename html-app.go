@@ -16,11 +16,15 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
 )
 
 var canvas blockartlib.Canvas
 var settings blockartlib.CanvasSettings
 
+// canvas shown; empty for the network's own canvas
+var canvasID string
+
 type bTNode struct {
 	hash        string
 	shapeHashes []string
@@ -102,13 +106,16 @@ func buildTreeHelper(todo []PC) {
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("Usage: go run html-app.go [minerAddr ip:port] [privKey]")
+	if len(os.Args) != 3 && len(os.Args) != 4 {
+		fmt.Println("Usage: go run html-app.go [minerAddr ip:port] [privKey] [canvasID (optional)]")
 		os.Exit(1)
 	}
 
 	minerAddr := os.Args[1]
 	privKeyArg := os.Args[2]
+	if len(os.Args) == 4 {
+		canvasID = os.Args[3]
+	}
 
 	privKeyStr, err := hex.DecodeString(privKeyArg)
 	if err != nil {
@@ -121,7 +128,7 @@ func main() {
 	privKey := *privKeyParsed
 
 	// Open a canvas.
-	canvas, settings, err = blockartlib.OpenCanvas(minerAddr, privKey)
+	canvas, settings, err = blockartlib.OpenCanvasWithID(minerAddr, privKey, canvasID)
 	if checkError(err) != nil {
 		return
 	}
@@ -171,11 +178,20 @@ func main() {
 			if checkError(err) != nil {
 				return
 			}
+			if blockartlib.SvgStringCanvas(svgString) != canvasID {
+				// shape is on another canvas
+				continue
+			}
 
 			svgStrings = append(svgStrings, svgString)
 		}
 	}
 
+	// draw higher layers over lower ones; within a layer, keep chain order
+	sort.SliceStable(svgStrings, func(i, j int) bool {
+		return blockartlib.SvgStringLayer(svgStrings[i]) < blockartlib.SvgStringLayer(svgStrings[j])
+	})
+
 	// write html file
 	file, _ := os.OpenFile("./html-app.html", os.O_RDWR|os.O_CREATE, 0666)
 	file.Write([]byte(fmt.Sprintf("<svg style=\"height: %dpx; width: %dpx\">\n", settings.CanvasXMax, settings.CanvasYMax)))
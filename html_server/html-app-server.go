@@ -18,6 +18,7 @@ import (
 	"net/http"
 	"os"
 	"crypto/x509"
+	"sort"
 	"sync"
 	"encoding/hex"
 )
@@ -25,6 +26,9 @@ import (
 var canvas blockartlib.Canvas
 var settings blockartlib.CanvasSettings
 
+// canvas shown; empty for the network's own canvas
+var canvasID string
+
 type bTNode struct {
 	hash        string
 	shapeHashes []string
@@ -176,11 +180,20 @@ func getShapes() []string {
 			if checkError(err) != nil {
 				continue
 			}
+			if blockartlib.SvgStringCanvas(svgString) != canvasID {
+				// shape is on another canvas
+				continue
+			}
 
 			svgStrings = append(svgStrings, svgString)
 		}
 	}
 
+	// draw higher layers over lower ones; within a layer, keep chain order
+	sort.SliceStable(svgStrings, func(i, j int) bool {
+		return blockartlib.SvgStringLayer(svgStrings[i]) < blockartlib.SvgStringLayer(svgStrings[j])
+	})
+
 	return svgStrings
 }
 
@@ -201,13 +214,16 @@ func pollTree() {
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("Usage: go run html-app.go [minerAddr ip:port] [privKey]")
+	if len(os.Args) != 3 && len(os.Args) != 4 {
+		fmt.Println("Usage: go run html-app.go [minerAddr ip:port] [privKey] [canvasID (optional)]")
 		os.Exit(1)
 	}
 
 	minerAddr := os.Args[1]
 	privKeyArg := os.Args[2]
+	if len(os.Args) == 4 {
+		canvasID = os.Args[3]
+	}
 
 	privKeyStr, err := hex.DecodeString(privKeyArg)
 	if err != nil {
@@ -220,7 +236,7 @@ func main() {
 	privKey := *privKeyParsed
 
 	// Open a canvas.
-	canvas, settings, err = blockartlib.OpenCanvas(minerAddr, privKey)
+	canvas, settings, err = blockartlib.OpenCanvasWithID(minerAddr, privKey, canvasID)
 	if checkError(err) != nil {
 		return
 	}
@@ -16,12 +16,13 @@ import (
 	"./proj1-server/rpcCommunication"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/md5"
 	"bytes"
 	"crypto/rand"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
+	"html"
+	"math"
 	"math/big"
 	"net"
 	"net/rpc"
@@ -43,6 +44,26 @@ var publicKeyString string
 var currBlock *Block
 var blockLock = &sync.Mutex{}
 
+// Mempool; guarded by blockLock
+var pool = newMempool()
+
+// maximum number of ops held in the mempool, in total and for each owner
+const maxMempoolOps = 5000
+const maxMempoolOpsPerOwner = 100
+
+// longest a region claim can last for, in blocks
+const maxClaimBlocks = 1000
+
+// longest ID, and largest dimension, of a canvas created on the network
+const maxCanvasIDLength = 64
+const maxCanvasDimension = 1 << 16
+
+// ops that have waited this long without being put into a block are dropped from the mempool
+const mempoolOpExpiry = 10 * time.Minute
+
+// maximum number of ops put into a block this miner mines
+const maxOpsPerBlock = maxOpsPerRequest
+
 // Head block
 var headBlockMeta *BlockMeta
 var headBlockLock = &sync.Mutex{}
@@ -61,10 +82,43 @@ var incomingAddress string
 // Network Instructions
 var minerNetSettings *rpcCommunication.MinerNetSettings
 
+// Block timestamps
+// a block's timestamp must be later than the median timestamp of this many preceding blocks
+const medianTimeSpan = 11
+
+// a block's timestamp may be at most this far ahead of this miner's clock
+const maxBlockTimeDrift = 2 * time.Minute
+
+// Canvas state after each validated block near the tip of the block tree, by block hash
+var canvasStates = make(map[string]cachedCanvasState)
+var canvasStatesLock = &sync.Mutex{}
+
+// length of the longest chain whose canvas state has been cached; guarded by canvasStatesLock
+var canvasStatesTip = 0
+
+// canvas states are cached only for blocks this close to canvasStatesTip; older ones are rebuilt when needed
+const canvasStateCacheDepth = 64
+
+// Chain synchronization
+// maximum number of headers returned by MinMin.RequestHeaders
+const maxHeadersPerRequest = 500
+
+// maximum number of blocks, and of ops in those blocks, returned by MinMin.RequestBlocks
+const maxBlocksPerRequest = 100
+const maxOpsPerRequest = 1000
+
+var syncing = false
+var syncingLock = &sync.Mutex{}
+
 // slice of operation threads' channels that need to know about new blocks
 var opChans = make(map[string](chan *BlockMeta))
 var opChansLock = &sync.Mutex{}
 
+// channels of operation threads that need to know when the head block switches to another chain, keyed
+// like opChans
+var reorgChans = make(map[string](chan reorgEvent))
+var reorgChansLock = &sync.Mutex{}
+
 type OpMeta struct {
 	hash blockartlib.Hash
 	r, s big.Int
@@ -90,10 +144,37 @@ func (o *OpMeta) GobDecode(buf []byte) error {
 	return decoder.Decode(&o.op)
 }
 
+// Kinds of op; an op's kind selects which of its fields are used.
+type opKind uint8
+
+const (
+	ADD_OP            opKind = iota // adds shapeMeta.
+	DELETE_OP                       // removes the shape with hash deleteShapeHash.
+	TRANSFER_OP                     // moves transferAmount ink from owner to transferTo.
+	TRANSFER_SHAPE_OP               // moves the shape with hash transferShapeHash from owner to transferTo.
+	UPDATE_OP                       // replaces the shape with hash deleteShapeHash by shapeMeta.
+	CLAIM_OP                        // reserves claimRegion for claimBlocks blocks, holding deposit ink.
+	GRANT_OP                        // lets transferTo draw in the region of the claim with hash claimHash.
+	CREATE_CANVAS_OP                // creates the canvas canvasID, with canvasSettings and canvasRules.
+)
+
 type Op struct {
-	shapeMeta       blockartlib.ShapeMeta // not nil iff adding shape.
-	deleteShapeHash string                 // non-empty iff removing shape.
-	owner           string        // public key of miner that issued this op.
+	kind              opKind
+	shapeMeta         blockartlib.ShapeMeta // not nil iff adding or updating shape.
+	deleteShapeHash   string                // non-empty iff removing or updating shape.
+	owner             string                // public key of miner that issued this op.
+	fee               uint32                // ink paid by the owner to the miner of the block that includes this op.
+	transferTo        string                // public key of the miner receiving ink or a shape.
+	transferAmount    uint32                // ink sent to transferTo.
+	transferShapeHash string                // shape handed to transferTo.
+	timestamp         int64                 // when the op was made; tells apart transfers that are otherwise the same.
+	claimRegion       blockartlib.Box       // region reserved by a claim.
+	claimBlocks       uint32                // number of blocks a claim lasts for.
+	deposit           uint32                // ink held by a claim until it expires.
+	claimHash         string                // claim whose region is granted.
+	canvasID          string                // canvas a claim is on, or that is created; empty for the network's canvas.
+	canvasSettings    blockartlib.CanvasSettings
+	canvasRules       blockartlib.CanvasRules
 }
 
 func (o *Op) GobEncode() ([]byte, error) {
@@ -102,6 +183,19 @@ func (o *Op) GobEncode() ([]byte, error) {
 	encoder.Encode(o.shapeMeta)
 	encoder.Encode(o.deleteShapeHash)
 	encoder.Encode(o.owner)
+	encoder.Encode(o.fee)
+	encoder.Encode(o.kind)
+	encoder.Encode(o.transferTo)
+	encoder.Encode(o.transferAmount)
+	encoder.Encode(o.timestamp)
+	encoder.Encode(o.transferShapeHash)
+	encoder.Encode(o.claimRegion)
+	encoder.Encode(o.claimBlocks)
+	encoder.Encode(o.deposit)
+	encoder.Encode(o.claimHash)
+	encoder.Encode(o.canvasID)
+	encoder.Encode(o.canvasSettings)
+	encoder.Encode(o.canvasRules)
 	return w.Bytes(), nil
 }
 
@@ -110,7 +204,20 @@ func (o *Op) GobDecode(buf []byte) error {
 	decoder := gob.NewDecoder(r)
 	decoder.Decode(&o.shapeMeta)
 	decoder.Decode(&o.deleteShapeHash)
-	return decoder.Decode(&o.owner)
+	decoder.Decode(&o.owner)
+	decoder.Decode(&o.fee)
+	decoder.Decode(&o.kind)
+	decoder.Decode(&o.transferTo)
+	decoder.Decode(&o.transferAmount)
+	decoder.Decode(&o.timestamp)
+	decoder.Decode(&o.transferShapeHash)
+	decoder.Decode(&o.claimRegion)
+	decoder.Decode(&o.claimBlocks)
+	decoder.Decode(&o.deposit)
+	decoder.Decode(&o.claimHash)
+	decoder.Decode(&o.canvasID)
+	decoder.Decode(&o.canvasSettings)
+	return decoder.Decode(&o.canvasRules)
 }
 
 func (o Op) ToString() string {
@@ -144,32 +251,44 @@ func (b *BlockMeta) GobDecode(buf []byte) error {
 }
 
 type Block struct {
-	prev  blockartlib.Hash
-	ops   []OpMeta
-	len   int
-	miner string // public key of the miner that mined this block.
-	nonce string
+	version    uint8 // block header version; selects the hashing algorithm.
+	prev       blockartlib.Hash
+	ops        []OpMeta
+	merkleRoot blockartlib.Hash // Merkle root of the hashes of ops.
+	stateRoot  blockartlib.Hash // commitment to the canvas state after applying this block.
+	len        int
+	miner      string // public key of the miner that mined this block.
+	nonce      string
+	timestamp  int64 // time at which the block was mined, in nanoseconds since the Unix epoch.
 }
 
 func (b *Block) GobEncode() ([]byte, error) {
 	w := new(bytes.Buffer)
 	encoder := gob.NewEncoder(w)
+	encoder.Encode(b.version)
 	encoder.Encode(b.prev)
 	encoder.Encode(b.ops)
+	encoder.Encode(b.merkleRoot)
+	encoder.Encode(b.stateRoot)
 	encoder.Encode(b.len)
 	encoder.Encode(b.miner)
 	encoder.Encode(b.nonce)
+	encoder.Encode(b.timestamp)
 	return w.Bytes(), nil
 }
 
 func (b *Block) GobDecode(buf []byte) error {
 	r := bytes.NewBuffer(buf)
 	decoder := gob.NewDecoder(r)
-	err := decoder.Decode(&b.prev)
+	err := decoder.Decode(&b.version)
+	err = decoder.Decode(&b.prev)
 	err = decoder.Decode(&b.ops)
+	err = decoder.Decode(&b.merkleRoot)
+	err = decoder.Decode(&b.stateRoot)
 	err = decoder.Decode(&b.len)
 	err = decoder.Decode(&b.miner)
 	err = decoder.Decode(&b.nonce)
+	err = decoder.Decode(&b.timestamp)
 	return err
 }
 
@@ -177,6 +296,33 @@ func (b Block) ToString() string {
 	return fmt.Sprintf("%+v", b)
 }
 
+// Returns the block's header, without its hash.
+func (b Block) header() blockartlib.BlockHeader {
+	return blockartlib.BlockHeader{
+		Version:    b.version,
+		PrevHash:   b.prev.ToString(),
+		Len:        b.len,
+		Miner:      b.miner,
+		Nonce:      b.nonce,
+		Timestamp:  b.timestamp,
+		NumOps:     len(b.ops),
+		MerkleRoot: b.merkleRoot,
+		StateRoot:  b.stateRoot,
+	}
+}
+
+// A switch of the head block to a chain that does not extend the old head.
+type reorgEvent struct {
+	oldHead  *BlockMeta
+	newHead  *BlockMeta
+	ancestor *BlockMeta // most recent block on both chains
+	depth    int        // number of blocks on the old chain that are no longer on the longest chain
+
+	orphanedOps []OpMeta        // ops in those blocks that are not on the new chain, oldest first
+	requeuedOps []OpMeta        // orphaned ops that are still valid, and were put back into the mempool
+	newOps      map[string]bool // hashes of the ops on the new chain since the common ancestor
+}
+
 type InkMiner struct {
 	conn    *rpc.Client
 	address net.Addr
@@ -197,6 +343,12 @@ func (e BlockVerificationError) Error() string {
 	return fmt.Sprintf("InkMiner: Block with hash %s could not be verified", string(e))
 }
 
+type BlockTimestampError string
+
+func (e BlockTimestampError) Error() string {
+	return fmt.Sprintf("InkMiner: Block with hash %s has an invalid timestamp", string(e))
+}
+
 type ServerConnectionError string
 
 func (e ServerConnectionError) Error() string {
@@ -257,37 +409,65 @@ func (m *MinMin) NotifyNewBlock(blockMeta *BlockMeta, reply *bool) error {
 	}
 
 	*reply = true
+	updateHead(blockMeta)
+
+	floodBlock(*blockMeta)
+
+	return nil
+}
+
+// Makes blockMeta the head block if its chain is longer than the current head's, and notifies all
+// opChans of the block.
+// If the new head does not extend the old head, the chain is reorganized: ops in blocks that fell off
+// the longest chain are put back into the mempool if they are still valid, and a reorgEvent is emitted.
+// In both cases currBlock is moved on top of the new head, and the mempool keeps only the ops that are
+// not on the new chain and are still valid.
+// - ASSUMES that blockMeta's chain has been validated and stored in blockTree
+// LOCKS: Acquires and releases headBlockLock, then blockLock
+// @param blockMeta *BlockMeta: Block which was added to chain.
+func updateHead(blockMeta *BlockMeta) {
 	headBlockLock.Lock()
 	defer headBlockLock.Unlock()
 
 	if blockMeta.block.len > headBlockMeta.block.len {
 		// head block is about to change
-		if !ecdsa.Verify(&publicKey, blockMeta.hash, &blockMeta.r, &blockMeta.s) {
-			// head block was not generated by this miner;
-			// need to update currBlock and copy over any ops that are not in this
-			// new block
-			oldOps := currBlock.ops
-			currBlock.ops = []OpMeta{}
-			verificationChan := make(chan error, 1)
-
-			for _, oldOp := range oldOps {
-				// go through ops sequentially for simplicity
-				// TODO - if runtime is really bad, could make it parallel
-				pseudoCurrBlockMeta := BlockMeta{block: *currBlock}
-				go verifyOp(oldOp, &pseudoCurrBlockMeta, -1, verificationChan)
-				err := <-verificationChan
-				if err == nil {
-					// op is still valid
-					currBlock.ops = append(currBlock.ops, oldOp)
-				}
-			}
-			close(verificationChan)
+		oldHead := headBlockMeta
+		headBlockMeta = blockMeta
 
-			currBlock.prev = blockMeta.hash
+		blockLock.Lock()
+		pending := pool.entries
+		onChain := make(map[string]bool)
+		for _, opMeta := range blockMeta.block.ops {
+			onChain[opMeta.hash.ToString()] = true
 		}
 
-		// update headBlockMeta
-		headBlockMeta = blockMeta
+		var event *reorgEvent
+		if blockMeta.block.prev.ToString() != oldHead.hash.ToString() {
+			event = reorganize(oldHead, blockMeta)
+			onChain = event.newOps
+			// orphaned ops came before the ops in the mempool, so they go first
+			orphaned := []*mempoolEntry{}
+			for _, opMeta := range event.orphanedOps {
+				orphaned = append(orphaned, &mempoolEntry{opMeta: opMeta, received: time.Now()})
+			}
+			pending = append(orphaned, pending...)
+		}
+
+		// start a new block on top of the new head, and put back the ops that are still valid
+		currBlock = &Block{version: networkBlockVersion(), prev: headBlockMeta.hash, len: headBlockMeta.block.len + 1, miner: publicKeyString}
+		requeued := refillMempool(pending, onChain)
+		blockLock.Unlock()
+
+		if event != nil {
+			for _, opMeta := range requeued {
+				for _, orphaned := range event.orphanedOps {
+					if opMetasEqual(opMeta, orphaned) {
+						event.requeuedOps = append(event.requeuedOps, opMeta)
+					}
+				}
+			}
+			emitReorg(*event)
+		}
 	}
 
 	// notify all opChans
@@ -298,10 +478,85 @@ func (m *MinMin) NotifyNewBlock(blockMeta *BlockMeta, reply *bool) error {
 		}()
 	}
 	opChansLock.Unlock()
+}
 
-	floodBlock(*blockMeta)
+// Works out what switching the head block from oldHead to newHead leaves behind.
+// Nothing needs to be rolled back: the canvas state and ink ledger are kept per block (see canvasStateAt),
+// so those of the new head follow from the common ancestor's, and the old chain's stay cached in case
+// it becomes the longest chain again.
+// - ASSUMES that both chains are stored in blockTree
+// @param oldHead *BlockMeta: the head before the switch
+// @param newHead *BlockMeta: the head after the switch
+// @return *reorgEvent: the reorg, without requeuedOps
+func reorganize(oldHead *BlockMeta, newHead *BlockMeta) *reorgEvent {
+	event := &reorgEvent{oldHead: oldHead, newHead: newHead, newOps: make(map[string]bool)}
+
+	// walk both chains back to the block they share
+	oldCurr, newCurr := oldHead, newHead
+	oldBranch := []*BlockMeta{}
+	for oldCurr.hash.ToString() != newCurr.hash.ToString() {
+		if oldCurr.block.len >= newCurr.block.len {
+			oldBranch = append(oldBranch, oldCurr)
+			oldCurr = blockTree[oldCurr.block.prev.ToString()]
+		} else {
+			for _, opMeta := range newCurr.block.ops {
+				event.newOps[opMeta.hash.ToString()] = true
+			}
+			newCurr = blockTree[newCurr.block.prev.ToString()]
+		}
+	}
+	event.ancestor = oldCurr
+	event.depth = len(oldBranch)
 
-	return nil
+	// collect the old branch's ops, oldest block first, that the new branch does not have
+	for i := len(oldBranch) - 1; i >= 0; i-- {
+		for _, opMeta := range oldBranch[i].block.ops {
+			if !event.newOps[opMeta.hash.ToString()] {
+				event.orphanedOps = append(event.orphanedOps, opMeta)
+			}
+		}
+	}
+
+	return event
+}
+
+// Logs the reorg and sends it to all reorgChans.
+// The sends never block: a waiter whose channel already holds an event it has not read yet misses this
+// one, but it still finds out whether its op is on the new chain from the new head block.
+// LOCKS: Acquires and releases reorgChansLock
+// @param event reorgEvent: the reorg
+func emitReorg(event reorgEvent) {
+	fmt.Printf("Reorg: depth %d, head %s -> %s, common ancestor at length %d; %d ops orphaned, %d requeued\n",
+		event.depth, event.oldHead.hash.ToString(), event.newHead.hash.ToString(), event.ancestor.block.len,
+		len(event.orphanedOps), len(event.requeuedOps))
+
+	reorgChansLock.Lock()
+	for _, reorgChan := range reorgChans {
+		select {
+		case reorgChan <- event:
+		default:
+		}
+	}
+	reorgChansLock.Unlock()
+}
+
+// Returns true iff opMeta was in a block the reorg took off the longest chain, is not on the new chain, and
+// was not put back into the mempool.
+// @param event reorgEvent: the reorg
+// @param opMeta OpMeta: the op
+// @return bool
+func isOrphaned(event reorgEvent, opMeta OpMeta) bool {
+	for _, requeued := range event.requeuedOps {
+		if opMetasEqual(requeued, opMeta) {
+			return false
+		}
+	}
+	for _, orphaned := range event.orphanedOps {
+		if opMetasEqual(orphaned, opMeta) {
+			return true
+		}
+	}
+	return false
 }
 
 // Called when miner has been given this miner as a neighbour, to notify this miner
@@ -321,7 +576,10 @@ func (m *MinMin) NotifyNewNeighbour(addr net.Addr, reply *bool) error {
 		go inkMiner.conn.Call("MinMin.NotifyNewBlock", headBlockMeta, nil)
 
 		// send currently pending ops
-		for _, opMeta := range currBlock.ops {
+		blockLock.Lock()
+		pending := pool.ops()
+		blockLock.Unlock()
+		for _, opMeta := range pending {
 			go inkMiner.conn.Call("MinMin.NotifyNewOp", &opMeta, nil)
 		}
 	}
@@ -333,29 +591,154 @@ func (m *MinMin) NotifyNewNeighbour(addr net.Addr, reply *bool) error {
 // @param block *Block: Pointer to block specified by nonce.
 // @return error: Any errors produced in retrieval of block.
 func (m *MinMin) RequestBlock(hash *string, blockMeta *BlockMeta) error {
-	blockMeta = blockTree[*hash]
-	if blockMeta == nil {
-		fmt.Println(blockTree)
+	blockTreeLock.Lock()
+	found := blockTree[*hash]
+	blockTreeLock.Unlock()
+	if found == nil {
 		return BlockNotFoundError(*hash)
 	}
-	fmt.Println(blockMeta)
-	buffer := new(bytes.Buffer)
-	enc := gob.NewEncoder(buffer)
-	err := enc.Encode(blockMeta)
-	buffer = bytes.NewBuffer(buffer.Bytes())
-	e := new(BlockMeta)
-	dec := gob.NewDecoder(buffer)
-	err = dec.Decode(e)
 
-	return err
+	*blockMeta = *found
+	return nil
+}
+
+type RequestBlocksArgs struct {
+	// Hashes of the blocks to return. If empty, blocks are instead selected by height.
+	Hashes []string
+
+	// Range of chain lengths (heights) of the blocks to return, inclusive, on the chain
+	// ending at Tip (or on the longest chain if Tip is empty)
+	From, To int
+	Tip      string
+}
+
+type RequestBlocksReply struct {
+	// The requested blocks; oldest first if selected by height, otherwise in the order requested,
+	// skipping any that are not known. Stops early once the size limits are reached, but always
+	// contains at least one block if any are found.
+	Blocks []*BlockMeta
+}
+
+// Returns several blocks in one round trip, either by hash or by a range of heights along a chain.
+// At most maxBlocksPerRequest blocks, containing at most maxOpsPerRequest ops, are returned.
+// @param args *RequestBlocksArgs: the hashes or the range of heights of the blocks to be returned.
+// @param reply *RequestBlocksReply: the blocks.
+// @return error: BlockNotFoundError if Tip is not known.
+func (m *MinMin) RequestBlocks(args *RequestBlocksArgs, reply *RequestBlocksReply) error {
+	headBlockLock.Lock()
+	head := headBlockMeta
+	headBlockLock.Unlock()
+
+	blockTreeLock.Lock()
+	defer blockTreeLock.Unlock()
+
+	found := []*BlockMeta{}
+	if len(args.Hashes) > 0 {
+		for _, hash := range args.Hashes {
+			if blockMeta, ok := blockTree[hash]; ok && blockMeta != nil {
+				found = append(found, blockMeta)
+			}
+		}
+	} else {
+		curr := head
+		if args.Tip != "" {
+			curr = blockTree[args.Tip]
+		}
+		if curr == nil {
+			return BlockNotFoundError(args.Tip)
+		}
+
+		for curr != nil && !isGenesis(*curr) && curr.block.len >= args.From {
+			if curr.block.len <= args.To {
+				found = append(found, curr)
+			}
+			curr = blockTree[curr.block.prev.ToString()]
+		}
+
+		// reverse order of blocks, so the oldest is first
+		for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+			found[i], found[j] = found[j], found[i]
+		}
+	}
+
+	// enforce size limits
+	numOps := 0
+	for _, blockMeta := range found {
+		numOps += len(blockMeta.block.ops)
+		if len(reply.Blocks) > 0 && (len(reply.Blocks) == maxBlocksPerRequest || numOps > maxOpsPerRequest) {
+			break
+		}
+		reply.Blocks = append(reply.Blocks, blockMeta)
+	}
+
+	return nil
+}
+
+type RequestHeadersArgs struct {
+	// Range of chain lengths (heights) of the headers to return, inclusive
+	From, To int
+}
+
+type RequestHeadersReply struct {
+	// Headers of the blocks on the longest chain within the requested range, oldest first.
+	// At most maxHeadersPerRequest headers are returned.
+	Headers []blockartlib.BlockHeader
+	// Length of the longest chain
+	HeadLen int
+}
+
+// Returns the headers of the blocks on this miner's longest chain within a range of heights,
+// so that new miners can sync the header chain before downloading any blocks.
+// @param args *RequestHeadersArgs: range of heights of the headers to be returned.
+// @param reply *RequestHeadersReply: the headers, and the length of the longest chain.
+// @return error: Any errors produced in retrieval of headers.
+func (m *MinMin) RequestHeaders(args *RequestHeadersArgs, reply *RequestHeadersReply) error {
+	reply.Headers, reply.HeadLen = headersInRange(args.From, args.To)
+	return nil
+}
+
+// Returns the headers of the blocks on the longest chain within a range of heights, oldest first.
+// At most maxHeadersPerRequest headers are returned.
+// LOCKS: Acquires and releases headBlockLock, then blockTreeLock
+// @param from int: height of the first header to return
+// @param to int: height of the last header to return
+// @return []blockartlib.BlockHeader: the headers
+// @return int: length of the longest chain
+func headersInRange(from int, to int) ([]blockartlib.BlockHeader, int) {
+	headBlockLock.Lock()
+	curr := headBlockMeta
+	headBlockLock.Unlock()
+
+	if to-from+1 > maxHeadersPerRequest {
+		to = from + maxHeadersPerRequest - 1
+	}
+
+	blockTreeLock.Lock()
+	defer blockTreeLock.Unlock()
+	headLen := curr.block.len
+	headers := []blockartlib.BlockHeader{}
+	for curr != nil && !isGenesis(*curr) && curr.block.len >= from {
+		if curr.block.len <= to {
+			headers = append(headers, blockHeader(curr))
+		}
+		curr = blockTree[curr.block.prev.ToString()]
+	}
+
+	// reverse order of headers, so the oldest is first
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+
+	return headers, headLen
 }
 
 // RPC for blockartlib-miner connection
 type LibMin int
 
-// Returns the CanvasSettings
-// @param args int: required by Go's RPC; does nothing
-// @param reply *blockartlib.ConvasSettings: pointer to CanvasSettings that will be returned
+// Returns the CanvasSettings of the canvas the app works on
+// @param args *blockartlib.OpenCanvasArgs: the app's keys, and the ID of the canvas it works on
+// @param reply *blockartlib.OpenCanvasReply: contains the CanvasSettings, the network's settings the app
+//                                            needs, and any internal errors
 // @return error: Any errors produced
 func (l *LibMin) OpenCanvasIM(args *blockartlib.OpenCanvasArgs, reply *blockartlib.OpenCanvasReply) (err error) {
 	// Ensure art node has proper private & public keys.
@@ -382,7 +765,24 @@ func (l *LibMin) OpenCanvasIM(args *blockartlib.OpenCanvasArgs, reply *blockartl
 		return blockartlib.DisconnectedError("")
 	}
 
-	*reply = blockartlib.OpenCanvasReply{CanvasSettings: minerNetSettings.CanvasSettings}
+	headBlockLock.Lock()
+	head := headBlockMeta
+	headBlockLock.Unlock()
+
+	canvas, ok := canvasFor(canvasStateAt(head), args.CanvasID)
+	if !ok {
+		reply.Error = blockartlib.InvalidCanvasError(args.CanvasID)
+		return nil
+	}
+
+	*reply = blockartlib.OpenCanvasReply{
+		CanvasSettings:         canvas.settings,
+		BlockVersion:           networkBlockVersion(),
+		GenesisBlockHash:       minerNetSettings.GenesisBlockHash,
+		PoWDifficultyOpBlock:   minerNetSettings.PoWDifficultyOpBlock,
+		PoWDifficultyNoOpBlock: minerNetSettings.PoWDifficultyNoOpBlock,
+		InkPricing:             minerNetSettings.InkPricing,
+	}
 
 	return nil
 }
@@ -396,59 +796,50 @@ func (l *LibMin) AddShapeIM(args *blockartlib.AddShapeArgs, reply *blockartlib.A
 	op := Op{
 		shapeMeta: args.ShapeMeta,
 		owner:     publicKeyString,
+		fee:       args.Fee,
 	}
 
-	hash := hashOp(op)
-	r, s, err := ecdsa.Sign(rand.Reader, &privateKey, hash)
+	// ensure key is unique, even between add and delete shapes
+	blockMeta, err := submitOp(op, args.ShapeMeta.Hash+"a", args.ValidateNum)
 	if err != nil {
-		return err
-	}
-
-	opMeta := OpMeta{
-		hash: hash,
-		r:    *r,
-		s:    *s,
-		op:   op,
+		reply.Error = err
+		return nil
 	}
 
-	// set up channel for opReceiveNewBlock back result
-	returnChan := make(chan error)
-
-	// ensure hash is unique, even between add and delete shapes
-	opChansKey := args.ShapeMeta.Hash + "a"
-
-	// set up channel to receive new blocks
-	opChansLock.Lock()
-	opChan := make(chan *BlockMeta, 1)
-	opChans[opChansKey] = opChan
-	go opReceiveNewBlocks(opChan, returnChan, opMeta, args.ValidateNum)
-	opChansLock.Unlock()
+	reply.OpHash = hashOp(op).ToString()
+	reply.BlockHash = blockMeta.hash.ToString()
 
-	defer func(opChan chan *BlockMeta, returnChan chan error, key string) {
-		// clean up channels
-		close(returnChan)
-		opChansLock.Lock()
-		delete(opChans, key)
-		close(opChan)
-		opChansLock.Unlock()
-	}(opChan, returnChan, opChansKey)
+	// Get ink
+	var inkArgs int
+	return l.GetInkIM(inkArgs, &reply.InkRemaining)
+}
 
-	resultErr := <-returnChan
-	if resultErr != nil {
-		reply.Error = resultErr
-		return nil
+// Replaces the shape associated with the passed args.ShapeHash, if it exists and is owned by this miner, by
+// args.ShapeMeta in one op. Only the difference in ink between the two shapes is charged.
+// args.ValidateNum specifies the number of blocks (no-op or op) that must follow the block with this
+// operation in the block-chain along the longest path before the operation can return successfully.
+// @param args *blockartlib.UpdateShapeArgs: contains the old shape's hash, the new shape, the ValidateNum and the fee
+// @param reply *blockartlib.UpdateShapeReply: contains the new shape's op hash and block hash, the ink
+//                                             remaining, and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) UpdateShapeIM(args *blockartlib.UpdateShapeArgs, reply *blockartlib.UpdateShapeReply) (err error) {
+	// construct Op for the update
+	op := Op{
+		kind:            UPDATE_OP,
+		shapeMeta:       args.ShapeMeta,
+		deleteShapeHash: args.ShapeHash,
+		owner:           publicKeyString,
+		fee:             args.Fee,
 	}
 
-	reply.OpHash = hash.ToString()
-
-	// find block where this was added
-	_, blockMeta := findOpMeta(hash.ToString())
-	if blockMeta == nil {
-		// should never happen; just return an error
-		reply.Error = blockartlib.DisconnectedError("")
+	// ensure key is unique, even between add and delete shapes
+	blockMeta, err := submitOp(op, args.ShapeMeta.Hash+"u", args.ValidateNum)
+	if err != nil {
+		reply.Error = err
 		return nil
 	}
 
+	reply.OpHash = hashOp(op).ToString()
 	reply.BlockHash = blockMeta.hash.ToString()
 
 	// Get ink
@@ -476,12 +867,13 @@ func (l *LibMin) GetSvgStringIM(args *blockartlib.GetSvgStringArgs, reply *block
 
 	shapeMeta := opMeta.op.shapeMeta
 	var stroke, fill string
-	if opMeta.op.deleteShapeHash == "" {
-		// this is an add op
+	if opMeta.op.kind == ADD_OP || (opMeta.op.kind == UPDATE_OP && opMeta.op.shapeMeta.Hash == args.OpHash) {
+		// this op draws the shape
 		stroke = shapeMeta.Shape.BorderColor
 		fill = shapeMeta.Shape.FillColor
 	}
-	if opMeta.op.deleteShapeHash != "" {
+	if opMeta.op.deleteShapeHash == args.OpHash {
+		// this op erases the shape
 		shapeMetaPointer :=  findShapeMeta(opMeta.op.deleteShapeHash)
 		if shapeMetaPointer == nil {
 			reply.Error = blockartlib.InvalidShapeHashError(args.OpHash)
@@ -493,19 +885,55 @@ func (l *LibMin) GetSvgStringIM(args *blockartlib.GetSvgStringArgs, reply *block
 		fill = "white"
 	}
 
+	// shapes on the network's own canvas don't name it
+	attrs := fmt.Sprintf("data-layer=\"%d\"", shapeMeta.Shape.Layer)
+	if shapeMeta.Shape.Canvas != "" {
+		attrs += fmt.Sprintf(" data-canvas=\"%s\"", html.EscapeString(shapeMeta.Shape.Canvas))
+	}
+
+	// viewers insert svg strings into their pages as is, so every value from an op is escaped
+	stroke = html.EscapeString(stroke)
+	fill = html.EscapeString(fill)
+
 	// Return html-valid tag, of the form:
-	if !shapeMeta.Shape.IsCircle {
-		// <path d=[svgString] stroke=[stroke] fill=[fill]/>
-		reply.SvgString = fmt.Sprintf("<path d=\"%s\" stroke=\"%s\" fill=\"%s\"/>", shapeMeta.Shape.Svg, stroke, fill)
+	if shapeMeta.Shape.IsText {
+		// <path d=[strokes] stroke=[stroke] fill=[fill] data-layer=[layer] data-canvas=[canvas]/>
+		// the strokes of text are drawn as moved by its transform already
+		var d []string
+		for _, edge := range shapeMeta.Shape.Edges {
+			d = append(d, fmt.Sprintf("M %s %s L %s %s", formatSvgNumber(edge.Start.X), formatSvgNumber(edge.Start.Y),
+				formatSvgNumber(edge.End.X), formatSvgNumber(edge.End.Y)))
+		}
+		reply.SvgString = fmt.Sprintf("<path d=\"%s\" stroke=\"%s\" fill=\"%s\" %s/>",
+			strings.Join(d, " "), stroke, fill, attrs)
+		reply.Error = nil
+	} else if !shapeMeta.Shape.IsCircle {
+		// <path d=[svgString] stroke=[stroke] fill=[fill] fill-rule=[rule] transform=[transform] data-layer=[layer] data-canvas=[canvas]/>
+		// paths using svg's default fill rule or no transform don't name them
+		if shapeMeta.Shape.Transform != "" {
+			attrs = fmt.Sprintf("transform=\"%s\" %s", html.EscapeString(shapeMeta.Shape.Transform), attrs)
+		}
+		if shapeMeta.Shape.FillRule != blockartlib.NONZERO {
+			attrs = fmt.Sprintf("fill-rule=\"%s\" %s", shapeMeta.Shape.FillRule, attrs)
+		}
+		reply.SvgString = fmt.Sprintf("<path d=\"%s\" stroke=\"%s\" fill=\"%s\" %s/>",
+			html.EscapeString(shapeMeta.Shape.Svg), stroke, fill, attrs)
 		reply.Error = nil
 	} else {
-		reply.SvgString = fmt.Sprintf("<circle cx=\"%d\" cy=\"%d\" r=\"%d\" stroke=\"%s\" fill=\"%s\"/>",
-			shapeMeta.Shape.Cx, shapeMeta.Shape.Cy, shapeMeta.Shape.Radius, stroke, fill)
+		// the centre and radius of a circle are already moved by its transform
+		reply.SvgString = fmt.Sprintf("<circle cx=\"%s\" cy=\"%s\" r=\"%s\" stroke=\"%s\" fill=\"%s\" %s/>",
+			formatSvgNumber(shapeMeta.Shape.Cx), formatSvgNumber(shapeMeta.Shape.Cy), formatSvgNumber(shapeMeta.Shape.Radius),
+			stroke, fill, attrs)
 		reply.Error = nil
 	}
 	return nil
 }
 
+// Returns the shortest decimal form of a number in an svg string.
+func formatSvgNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
 // Returns the amount of ink remaining for this miner, in pixels
 // @param args args *int: dummy argument that is not used
 // @param reply *uint32: amount of remaining ink, in pixels
@@ -528,48 +956,51 @@ func (l *LibMin) GetInkIM(_unused int, reply *uint32) (err error) {
 func (l *LibMin) DeleteShapeIM(args *blockartlib.DeleteShapeArgs, reply *blockartlib.DeleteShapeReply) (err error) {
 	// construct Op for deletion
 	op := Op{
+		kind:            DELETE_OP,
 		deleteShapeHash: args.ShapeHash,
 		owner:           publicKeyString,
+		fee:             args.Fee,
 	}
 
-	hash := hashOp(op)
-	r, s, err := ecdsa.Sign(rand.Reader, &privateKey, hash)
-	if err != nil {
-		return err
-	}
-
-	opMeta := OpMeta{
-		hash: hash,
-		r:    *r,
-		s:    *s,
-		op:   op,
+	// ensure key is unique, even between add and delete shapes
+	if _, err := submitOp(op, args.ShapeHash+"d", args.ValidateNum); err != nil {
+		switch err.(type) {
+		case blockartlib.InsufficientInkError, blockartlib.MempoolFullError:
+			// the shape may well be this miner's; the op could not be paid for or queued
+			reply.Error = err
+		default:
+			reply.Error = blockartlib.ShapeOwnerError(args.ShapeHash)
+		}
+		return nil
 	}
 
-	// set up channel for opReceiveNewBlock back result
-	returnChan := make(chan error)
-
-	// ensure hash is unique, even between add and delete shapes
-	opChansKey := args.ShapeHash + "d"
+	// Get ink
+	var inkArgs int
+	return l.GetInkIM(inkArgs, &reply.InkRemaining)
+}
 
-	// set up channel to receive new blocks
-	opChansLock.Lock()
-	opChan := make(chan *BlockMeta, 1)
-	opChans[opChansKey] = opChan
-	go opReceiveNewBlocks(opChan, returnChan, opMeta, args.ValidateNum)
-	opChansLock.Unlock()
+// Transfers args.Amount ink from this miner to the miner with public key args.To.
+// args.ValidateNum specifies the number of blocks (no-op or op) that must follow the block with this
+// operation in the block-chain along the longest path before the operation can return successfully.
+// @param args *blockartlib.TransferInkArgs: contains the recipient, the amount, the ValidateNum and the fee
+// @param reply *blockartlib.TransferInkReply: contains the ink remaining, and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) TransferInkIM(args *blockartlib.TransferInkArgs, reply *blockartlib.TransferInkReply) (err error) {
+	// construct Op for the transfer
+	op := Op{
+		kind:           TRANSFER_OP,
+		owner:          publicKeyString,
+		fee:            args.Fee,
+		transferTo:     args.To,
+		transferAmount: args.Amount,
+		timestamp:      time.Now().UnixNano(),
+	}
 
-	defer func(opChan chan *BlockMeta, returnChan chan error, key string) {
-		// clean up channels
-		close(returnChan)
-		opChansLock.Lock()
-		delete(opChans, key)
-		close(opChan)
-		opChansLock.Unlock()
-	}(opChan, returnChan, opChansKey)
+	hash := hashOp(op)
 
-	resultErr := <-returnChan
-	if resultErr != nil {
-		reply.Error = blockartlib.ShapeOwnerError(args.ShapeHash)
+	// ensure key is unique, even between shape ops
+	if _, err := submitOp(op, hash.ToString()+"t", args.ValidateNum); err != nil {
+		reply.Error = err
 		return nil
 	}
 
@@ -578,38 +1009,281 @@ func (l *LibMin) DeleteShapeIM(args *blockartlib.DeleteShapeArgs, reply *blockar
 	return l.GetInkIM(inkArgs, &reply.InkRemaining)
 }
 
-// Returns the shape hashes contained by the block in BlockHash
-// NOTE: as per https://piazza.com/class/jbyh5bsk4ez3cn?cid=425,
-// do not search externally; assume that any external blocks will get
-// flooded to this miner soon.
-// @param args *string: the blockHash
-// @param reply *blockartlib.GetShapesReply: contains the slice of shape hashes and any internal errors
+// Hands the shape associated with the passed args.ShapeHash, if it exists and is owned by this miner, to the
+// miner with public key args.To, along with the right to delete it and receive its ink refund.
+// args.ValidateNum specifies the number of blocks (no-op or op) that must follow the block with this
+// operation in the block-chain along the longest path before the operation can return successfully.
+// @param args *blockartlib.TransferShapeArgs: contains the shape, the recipient, the ValidateNum and the fee
+// @param reply *blockartlib.TransferShapeReply: contains the ink remaining, and any internal errors
 // @param err error: Any errors produced
-func (l *LibMin) GetShapesIM(args *string, reply *blockartlib.GetShapesReply) (err error) {
-	// Search for block locally - if it does not exist, return an InvalidBlockHashError
-	blockMeta, ok := blockTree[*args]
-	if !ok || blockMeta == nil {
-		// block does not exist locally
-		reply.Error = blockartlib.InvalidBlockHashError(*args)
-		return nil
+func (l *LibMin) TransferShapeIM(args *blockartlib.TransferShapeArgs, reply *blockartlib.TransferShapeReply) (err error) {
+	// construct Op for the transfer
+	op := Op{
+		kind:              TRANSFER_SHAPE_OP,
+		owner:             publicKeyString,
+		fee:               args.Fee,
+		transferTo:        args.To,
+		transferShapeHash: args.ShapeHash,
+		timestamp:         time.Now().UnixNano(),
 	}
 
-	for _, opMeta := range blockMeta.block.ops {
-		// add op's hash to reply.ShapeHashes
-		hash := opMeta.op.shapeMeta.Hash
-		reply.ShapeHashes = append(reply.ShapeHashes, hash)
+	hash := hashOp(op)
+
+	// ensure key is unique, even between shape ops
+	if _, err := submitOp(op, hash.ToString()+"s", args.ValidateNum); err != nil {
+		reply.Error = err
+		return nil
 	}
 
-	reply.Error = nil
-	return nil
+	// Get ink
+	var inkArgs int
+	return l.GetInkIM(inkArgs, &reply.InkRemaining)
 }
 
-// Returns the hash of the genesis block of the block chain
-// @param args args *int: dummy argument that is not used
-// @param reply *uint32: hash of genesis block
+// Reserves args.Region of the canvas for args.Blocks blocks, holding args.Deposit ink until the claim expires.
+// Until then, only this miner and the miners it grants the claim to may draw shapes that touch the region.
+// args.ValidateNum specifies the number of blocks (no-op or op) that must follow the block with this
+// operation in the block-chain along the longest path before the operation can return successfully.
+// @param args *blockartlib.ClaimRegionArgs: contains the region, the length of the claim, the deposit,
+//                                           the ValidateNum and the fee
+// @param reply *blockartlib.ClaimRegionReply: contains the claim's hash, the ink remaining, and any internal errors
 // @param err error: Any errors produced
-func (l *LibMin) GetGenesisBlockIM(_unused int, reply *string) (err error) {
-	if minerNetSettings.GenesisBlockHash == blockartlib.Hash([]byte{}).ToString() {
+func (l *LibMin) ClaimRegionIM(args *blockartlib.ClaimRegionArgs, reply *blockartlib.ClaimRegionReply) (err error) {
+	// construct Op for the claim
+	op := Op{
+		kind:        CLAIM_OP,
+		owner:       publicKeyString,
+		fee:         args.Fee,
+		canvasID:    args.CanvasID,
+		claimRegion: args.Region,
+		claimBlocks: args.Blocks,
+		deposit:     args.Deposit,
+		timestamp:   time.Now().UnixNano(),
+	}
+
+	hash := hashOp(op)
+
+	// ensure key is unique, even between shape ops
+	if _, err := submitOp(op, hash.ToString()+"c", args.ValidateNum); err != nil {
+		reply.Error = err
+		return nil
+	}
+
+	// claims are identified by the hash of the op that made them
+	reply.ClaimHash = hash.ToString()
+
+	// Get ink
+	var inkArgs int
+	return l.GetInkIM(inkArgs, &reply.InkRemaining)
+}
+
+// Lets the miner with public key args.To draw in the region of the claim associated with args.ClaimHash, if it
+// is active and was made by this miner.
+// args.ValidateNum specifies the number of blocks (no-op or op) that must follow the block with this
+// operation in the block-chain along the longest path before the operation can return successfully.
+// @param args *blockartlib.GrantRegionArgs: contains the claim, the grantee, the ValidateNum and the fee
+// @param reply *blockartlib.GrantRegionReply: contains the ink remaining, and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) GrantRegionIM(args *blockartlib.GrantRegionArgs, reply *blockartlib.GrantRegionReply) (err error) {
+	// construct Op for the grant
+	op := Op{
+		kind:       GRANT_OP,
+		owner:      publicKeyString,
+		fee:        args.Fee,
+		transferTo: args.To,
+		claimHash:  args.ClaimHash,
+		timestamp:  time.Now().UnixNano(),
+	}
+
+	hash := hashOp(op)
+
+	// ensure key is unique, even between shape ops
+	if _, err := submitOp(op, hash.ToString()+"g", args.ValidateNum); err != nil {
+		reply.Error = err
+		return nil
+	}
+
+	// Get ink
+	var inkArgs int
+	return l.GetInkIM(inkArgs, &reply.InkRemaining)
+}
+
+// Creates the canvas args.CanvasID on the network, with args.Settings and args.Rules. This miner becomes its creator.
+// args.ValidateNum specifies the number of blocks (no-op or op) that must follow the block with this
+// operation in the block-chain along the longest path before the operation can return successfully.
+// @param args *blockartlib.CreateCanvasArgs: contains the canvas's ID, settings and rules, the ValidateNum and the fee
+// @param reply *blockartlib.CreateCanvasReply: contains the ink remaining, and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) CreateCanvasIM(args *blockartlib.CreateCanvasArgs, reply *blockartlib.CreateCanvasReply) (err error) {
+	// construct Op for the new canvas
+	op := Op{
+		kind:           CREATE_CANVAS_OP,
+		owner:          publicKeyString,
+		fee:            args.Fee,
+		canvasID:       args.CanvasID,
+		canvasSettings: args.Settings,
+		canvasRules:    args.Rules,
+		timestamp:      time.Now().UnixNano(),
+	}
+
+	hash := hashOp(op)
+
+	// ensure key is unique, even between shape ops
+	if _, err := submitOp(op, hash.ToString()+"n", args.ValidateNum); err != nil {
+		reply.Error = err
+		return nil
+	}
+
+	// Get ink
+	var inkArgs int
+	return l.GetInkIM(inkArgs, &reply.InkRemaining)
+}
+
+// Returns what the longest chain says about the shape associated with the passed shapeHash: its current
+// owner and the ink it used.
+// @param args *string: the shapeHash
+// @param reply *blockartlib.GetShapeInfoReply: contains the shape's info, and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) GetShapeInfoIM(args *string, reply *blockartlib.GetShapeInfoReply) (err error) {
+	headBlockLock.Lock()
+	head := headBlockMeta
+	headBlockLock.Unlock()
+
+	shape, live := canvasStateAt(head).shapes[*args]
+	if !live {
+		// shape was never added, or has been deleted
+		reply.Error = blockartlib.InvalidShapeHashError(*args)
+		return nil
+	}
+
+	reply.Info = blockartlib.ShapeInfo{Hash: *args, Owner: shape.owner, Ink: shape.ink, Layer: shape.shape.Layer,
+		Canvas: shape.shape.Canvas}
+	reply.Error = nil
+	return nil
+}
+
+// Checks the shape in args as verifyOp would check an op adding it for this miner, against the canvas at
+// the head of the longest chain, without creating an op.
+// @param args *blockartlib.ValidateShapeArgs: contains the shape and the fee an op adding it would pay
+// @param reply *blockartlib.ValidateShapeReply: contains the error the op would be refused with; nil if none
+// @param err error: Any errors produced
+func (l *LibMin) ValidateShapeIM(args *blockartlib.ValidateShapeArgs, reply *blockartlib.ValidateShapeReply) (err error) {
+	headBlockLock.Lock()
+	head := headBlockMeta
+	headBlockLock.Unlock()
+
+	state := canvasStateAt(head)
+	shapeMeta := args.ShapeMeta
+
+	// Verify shape.
+	if err := verifyNewShape(&shapeMeta, publicKeyString, state); err != nil {
+		reply.Error = err
+		return nil
+	}
+
+	// Ensure miner has enough ink for the shape and the fee.
+	if ink := state.ink[publicKeyString]; uint64(ink) < uint64(shapeMeta.Shape.Ink)+uint64(args.Fee) {
+		reply.Error = blockartlib.InsufficientInkError(ink)
+		return nil
+	}
+
+	// Ensure shape does not overlap with shapes on the canvas owned by someone else.
+	if err := verifyNoOverlap(shapeMeta, publicKeyString, state, ""); err != nil {
+		reply.Error = err
+		return nil
+	}
+
+	// Ensure shape is not in a region claimed by someone else.
+	reply.Error = verifyNotClaimed(shapeMeta.Shape, publicKeyString, state)
+	return nil
+}
+
+// Finds places on a layer of a canvas, as of the head of the longest chain, where a box of the passed size
+// touches no shape of another miner and no region claimed by another miner. Positions are tried on a grid,
+// by row from the top, then from the left.
+// @param args *blockartlib.FindFreeSpaceArgs: contains the canvas, the layer, the box's size and the most
+//                                             positions to return
+// @param reply *blockartlib.FindFreeSpaceReply: contains the top left corners of the boxes found, and any
+//                                               internal errors
+// @param err error: Any errors produced
+func (l *LibMin) FindFreeSpaceIM(args *blockartlib.FindFreeSpaceArgs, reply *blockartlib.FindFreeSpaceReply) (err error) {
+	headBlockLock.Lock()
+	head := headBlockMeta
+	headBlockLock.Unlock()
+
+	state := canvasStateAt(head)
+	canvas, ok := canvasFor(state, args.CanvasID)
+	if !ok || args.Layer > canvas.rules.MaxLayer {
+		reply.Error = blockartlib.InvalidCanvasError(args.CanvasID)
+		return nil
+	}
+	xMax := float64(canvas.settings.CanvasXMax)
+	yMax := float64(canvas.settings.CanvasYMax)
+	if !(args.Width > 0 && args.Height > 0) || args.Width > xMax || args.Height > yMax {
+		reply.Error = blockartlib.OutOfBoundsError{}
+		return nil
+	}
+	maxResults := args.MaxResults
+	if maxResults <= 0 || maxResults > maxFreeSpaceResults {
+		maxResults = maxFreeSpaceResults
+	}
+
+	// the grid is as fine as the index's, unless that would mean trying more than maxFreeSpaceProbes positions
+	step := math.Max(shapeIndexCellSize, math.Sqrt((xMax-args.Width+1)*(yMax-args.Height+1)/maxFreeSpaceProbes))
+	reply.Positions = []blockartlib.Point{}
+	for y := 0.0; y+args.Height <= yMax; y += step {
+		for x := 0.0; x+args.Width <= xMax; x += step {
+			box := blockartlib.Box{MinX: x, MinY: y, MaxX: x + args.Width, MaxY: y + args.Height}
+			if isBoxFree(box, args.CanvasID, args.Layer, publicKeyString, state) {
+				reply.Positions = append(reply.Positions, blockartlib.Point{X: x, Y: y})
+				if len(reply.Positions) == maxResults {
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the shape hashes contained by the block in BlockHash
+// NOTE: as per https://piazza.com/class/jbyh5bsk4ez3cn?cid=425,
+// do not search externally; assume that any external blocks will get
+// flooded to this miner soon.
+// @param args *string: the blockHash
+// @param reply *blockartlib.GetShapesReply: contains the slice of shape hashes and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) GetShapesIM(args *string, reply *blockartlib.GetShapesReply) (err error) {
+	// Search for block locally - if it does not exist, return an InvalidBlockHashError
+	blockMeta, ok := blockTree[*args]
+	if !ok || blockMeta == nil {
+		// block does not exist locally
+		reply.Error = blockartlib.InvalidBlockHashError(*args)
+		return nil
+	}
+
+	for _, opMeta := range blockMeta.block.ops {
+		// add the hashes of the shapes the op draws or erases to reply.ShapeHashes
+		switch opMeta.op.kind {
+		case ADD_OP:
+			reply.ShapeHashes = append(reply.ShapeHashes, opMeta.op.shapeMeta.Hash)
+		case DELETE_OP:
+			reply.ShapeHashes = append(reply.ShapeHashes, opMeta.op.deleteShapeHash)
+		case UPDATE_OP:
+			// the old shape is erased before the new one is drawn
+			reply.ShapeHashes = append(reply.ShapeHashes, opMeta.op.deleteShapeHash, opMeta.op.shapeMeta.Hash)
+		}
+	}
+
+	reply.Error = nil
+	return nil
+}
+
+// Returns the hash of the genesis block of the block chain
+// @param args args *int: dummy argument that is not used
+// @param reply *uint32: hash of genesis block
+// @param err error: Any errors produced
+func (l *LibMin) GetGenesisBlockIM(_unused int, reply *string) (err error) {
+	if minerNetSettings.GenesisBlockHash == blockartlib.Hash([]byte{}).ToString() {
 		return GensisBlockNotFound("")
 	}
 	*reply = minerNetSettings.GenesisBlockHash
@@ -644,14 +1318,89 @@ func (l *LibMin) GetChildrenIM(args *string, reply *blockartlib.GetChildrenReply
 	return nil
 }
 
+// Returns the header of the block identified by BlockHash
+// NOTE: as per https://piazza.com/class/jbyh5bsk4ez3cn?cid=425,
+// do not search externally; assume that any external blocks will get
+// flooded to this miner soon.
+// @param args *string: the blockHash
+// @param reply *blockartlib.GetBlockHeaderReply: contains the block header and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) GetBlockHeaderIM(args *string, reply *blockartlib.GetBlockHeaderReply) (err error) {
+	blockTreeLock.Lock()
+	blockMeta, ok := blockTree[*args]
+	blockTreeLock.Unlock()
+	if !ok || blockMeta == nil {
+		// block does not exist locally
+		reply.Error = blockartlib.InvalidBlockHashError(*args)
+		return nil
+	}
+
+	reply.Header = blockHeader(blockMeta)
+	reply.Error = nil
+	return nil
+}
+
+// Returns the headers of the longest chain from a height on, so that blockartlib can verify the chain
+// itself. At most maxHeadersPerRequest headers are returned; blockartlib asks for the rest in turn.
+// @param args *blockartlib.GetBlockHeadersArgs: contains the height of the first header to return
+// @param reply *blockartlib.GetBlockHeadersReply: contains the headers, the length of the longest chain,
+//                                                 and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) GetBlockHeadersIM(args *blockartlib.GetBlockHeadersArgs, reply *blockartlib.GetBlockHeadersReply) (err error) {
+	reply.Headers, reply.HeadLen = headersInRange(args.From, args.From+maxHeadersPerRequest-1)
+	reply.Error = nil
+	return nil
+}
+
+// Returns a proof that the shape identified by args was added in a block on the longest chain,
+// which can be checked against that block's header with blockartlib.VerifyShapeProof
+// @param args *string: the shapeHash
+// @param reply *blockartlib.GetShapeProofReply: contains the proof and any internal errors
+// @param err error: Any errors produced
+func (l *LibMin) GetShapeProofIM(args *string, reply *blockartlib.GetShapeProofReply) (err error) {
+	headBlockLock.Lock()
+	curr := headBlockMeta
+	headBlockLock.Unlock()
+
+	blockTreeLock.Lock()
+	defer blockTreeLock.Unlock()
+	for curr != nil && !isGenesis(*curr) {
+		for i, opMeta := range curr.block.ops {
+			if *args == "" || opMeta.op.shapeMeta.Hash != *args {
+				continue
+			}
+
+			// found the op that added the shape
+			leaves := make([]blockartlib.Hash, len(curr.block.ops))
+			for j, leafOpMeta := range curr.block.ops {
+				leaves[j] = leafOpMeta.hash
+			}
+			reply.Proof = blockartlib.ShapeProof{
+				ShapeHash:   *args,
+				BlockHash:   curr.hash.ToString(),
+				ContentHash: opContentHash(opMeta.op),
+				Steps:       blockartlib.MerkleProof(curr.block.version, leaves, i),
+			}
+			reply.Error = nil
+			return nil
+		}
+		curr = blockTree[curr.block.prev.ToString()]
+	}
+
+	// shape is not on the longest chain
+	reply.Error = blockartlib.InvalidShapeHashError(*args)
+	return nil
+}
+
 // This helper function receives information about new blocks for the purpose of ensuring an operation
 // is successfully added to the blockchain
 // @param opChan: channel through which new blocks will be sent
+// @param reorgChan: channel through which switches of the head block to another chain will be sent
 // @param returnChan: channel through which the result of this function should be sent
 // @param opMeta: the opMeta we're trying to get added to the blockchain
 // @param validateNum: the number of blocks required after a block containing opMeta in the blockchain
 //                     for the add to ba success
-func opReceiveNewBlocks(opChan chan *BlockMeta, returnChan chan error, opMeta OpMeta, validateNum uint8) {
+func opReceiveNewBlocks(opChan chan *BlockMeta, reorgChan chan reorgEvent, returnChan chan error, opMeta OpMeta, validateNum uint8) {
 	// receiveNewOp will try to add opMeta to current block and flood opMeta
 	if err := receiveNewOp(opMeta); err != nil {
 		// return error in reply so that it is not cast
@@ -661,7 +1410,20 @@ func opReceiveNewBlocks(opChan chan *BlockMeta, returnChan chan error, opMeta Op
 
 	// wait for op to be added
 	for {
-		blockMeta := <-opChan
+		var blockMeta *BlockMeta
+		select {
+		case blockMeta = <-opChan:
+		case event := <-reorgChan:
+			if isOrphaned(event, opMeta) {
+				// opMeta fell off the longest chain, and was not put back into the mempool; unless it has been
+				// since, it is no longer valid
+				if err := receiveNewOp(opMeta); err != nil {
+					returnChan <- err
+					return
+				}
+			}
+			continue
+		}
 		// idea - see if opMeta appears in the chain for this block
 		// if it does, check that validateNum number of blocks have been added on top
 		// if it is not, and this is the new head, resend the block
@@ -704,6 +1466,68 @@ func opReceiveNewBlocks(opChan chan *BlockMeta, returnChan chan error, opMeta Op
 	}
 }
 
+// Signs op, puts it in the mempool and floods it, then waits until validateNum blocks follow the block
+// holding it on the longest chain.
+// @param op Op: the op, issued by this miner
+// @param key string: key of the op's channel in opChans; unique among the ops being waited on
+// @param validateNum uint8: the number of blocks that must follow the block holding the op
+// @return *BlockMeta: the block holding the op
+// @return error: any error that kept the op off the longest chain
+func submitOp(op Op, key string, validateNum uint8) (*BlockMeta, error) {
+	hash := hashOp(op)
+	r, s, err := ecdsa.Sign(rand.Reader, &privateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	opMeta := OpMeta{
+		hash: hash,
+		r:    *r,
+		s:    *s,
+		op:   op,
+	}
+
+	// set up channel for opReceiveNewBlock back result
+	returnChan := make(chan error)
+
+	// set up channel to receive new blocks
+	opChansLock.Lock()
+	opChan := make(chan *BlockMeta, 1)
+	opChans[key] = opChan
+	reorgChansLock.Lock()
+	reorgChan := make(chan reorgEvent, 1)
+	reorgChans[key] = reorgChan
+	reorgChansLock.Unlock()
+
+	go opReceiveNewBlocks(opChan, reorgChan, returnChan, opMeta, validateNum)
+	opChansLock.Unlock()
+
+	defer func() {
+		// clean up channels
+		close(returnChan)
+		opChansLock.Lock()
+		delete(opChans, key)
+		close(opChan)
+		opChansLock.Unlock()
+		reorgChansLock.Lock()
+		delete(reorgChans, key)
+		close(reorgChan)
+		reorgChansLock.Unlock()
+	}()
+
+	if err := <-returnChan; err != nil {
+		return nil, err
+	}
+
+	// find block where this was added
+	_, blockMeta := findOpMeta(hash.ToString())
+	if blockMeta == nil {
+		// should never happen; just return an error
+		return nil, blockartlib.DisconnectedError("")
+	}
+	return blockMeta, nil
+}
+
 // Compares two OpMetas, and returns true if they are equal
 // @param block1: the first OpMeta to compare
 // @param block2: the second OpMeta to compare
@@ -730,7 +1554,7 @@ func blockMetasEqual(blockMeta1 BlockMeta, blockMeta2 BlockMeta) bool {
 // @param block2: the second block to compare
 // @return bool: true if the blocks are equal, false otherwise
 func blocksEqual(block1 Block, block2 Block) bool {
-	if block1.prev.ToString() != block2.prev.ToString() || block1.len != block2.len || block1.nonce != block2.nonce || len(block1.ops) != len(block2.ops) {
+	if block1.version != block2.version || block1.prev.ToString() != block2.prev.ToString() || block1.merkleRoot.ToString() != block2.merkleRoot.ToString() || block1.stateRoot.ToString() != block2.stateRoot.ToString() || block1.len != block2.len || block1.nonce != block2.nonce || block1.timestamp != block2.timestamp || len(block1.ops) != len(block2.ops) {
 		return false
 	}
 
@@ -769,6 +1593,11 @@ func findOpMeta(opHash string) (*OpMeta, *BlockMeta) {
 // @param opHash string: hash of opMeta that is being searched for
 // @return shape: found op whose hash matches opHash; nil if it does not exist
 func findOpMetaWithShape(shapeHash string) (retOpMeta *OpMeta, retBlockMeta *BlockMeta) {
+	if shapeHash == "" {
+		// ops that do not draw or erase a shape have empty shape hashes
+		return nil, nil
+	}
+
 	// Iterate through all locally stored blocks to search for a shape with the passed hash
 	blockTreeLock.Lock()
 	defer blockTreeLock.Unlock()
@@ -776,10 +1605,10 @@ func findOpMetaWithShape(shapeHash string) (retOpMeta *OpMeta, retBlockMeta *Blo
 		block := blockMeta.block
 		// search through the block's ops
 		for _, opMeta := range block.ops {
-			if  opMeta.op.deleteShapeHash == shapeHash {
+			if opMeta.op.deleteShapeHash == shapeHash {
 				// opMeta was found
 				return &opMeta, blockMeta
-			} else if opMeta.op.deleteShapeHash == "" && opMeta.op.shapeMeta.Hash == shapeHash {
+			} else if opMeta.op.shapeMeta.Hash == shapeHash {
 				retOpMeta = &opMeta
 				retBlockMeta = blockMeta
 			}
@@ -802,7 +1631,7 @@ func findShapeMeta(shapeHash string) (shapeMeta *blockartlib.ShapeMeta) {
 		block := blockMeta.block
 		// search through the block's ops
 		for _, opMeta := range block.ops {
-			if opMeta.op.deleteShapeHash == "" && opMeta.op.shapeMeta.Hash == shapeHash {
+			if shapeHash != "" && opMeta.op.shapeMeta.Hash == shapeHash {
 				// shapeMeta was found
 				return &opMeta.op.shapeMeta
 			}
@@ -842,6 +1671,8 @@ func crawlChain(blockMeta *BlockMeta, fn func(*BlockMeta, interface{}, interface
 
 	// the chain, starting at headBlock
 	chain := []*BlockMeta{}
+	// blocks fetched from other miners while building the chain, by hash
+	fetched := make(map[string]*BlockMeta)
 	curr := blockMeta
 	for {
 		// add current element to the end of the chain
@@ -852,7 +1683,7 @@ func crawlChain(blockMeta *BlockMeta, fn func(*BlockMeta, interface{}, interface
 			break
 		}
 
-		parent := crawlChainHelperGetBlock(curr.block.prev)
+		parent := crawlChainHelperGetBlock(curr.block.prev, curr.block.len-1, fetched)
 		if parent == nil {
 			// If the parent could not be found, then the hash is invalid.
 			return blockartlib.InvalidBlockHashError(curr.hash.ToString())
@@ -906,29 +1737,47 @@ func crawlNoopHelper(blockMeta *BlockMeta, args interface{}, reply interface{})
 }
 
 // Returns block with given hash.
-// If the block is not stored locally, try to get the block from another miner.
+// If the block is not stored locally, try to get the block from another miner. Since the
+// block's ancestors are likely to be needed next, it is fetched along with up to
+// maxBlocksPerRequest of them, which are stored in fetched.
 // NOTE: this operation does no verification on any external blocks.
 // @param hash blockartlib.Hash: The hash of the block to get info on.
+// @param height int: The length of the chain ending at the block, according to its child.
+// @param fetched map[string]*BlockMeta: Blocks already fetched from other miners, by hash.
 // @return Block: The requested block, or nil if no block is found.
-func crawlChainHelperGetBlock(hash blockartlib.Hash) (blockMeta *BlockMeta) {
+func crawlChainHelperGetBlock(hash blockartlib.Hash, height int, fetched map[string]*BlockMeta) (blockMeta *BlockMeta) {
 	// Search locally.
 	if blockMeta, ok := blockTree[hash.ToString()]; ok && blockMeta != nil {
 		return blockMeta
 	}
+	if blockMeta, ok := fetched[hash.ToString()]; ok {
+		return blockMeta
+	}
 
 	// block is not stored locally, search externally.
+	// don't hold neighboursLock while waiting on other miners
 	neighboursLock.Lock()
-	defer neighboursLock.Unlock()
+	peers := []InkMiner{}
 	for _, n := range neighbours {
-		args := hash.ToString()
-		blockMeta = &BlockMeta{}
-		err := n.conn.Call("MinMin.RequestBlock", &args, blockMeta)
-		if err != nil {
+		peers = append(peers, n)
+	}
+	neighboursLock.Unlock()
+
+	args := RequestBlocksArgs{From: height - maxBlocksPerRequest + 1, To: height, Tip: hash.ToString()}
+	for _, n := range peers {
+		var reply RequestBlocksReply
+		err := n.conn.Call("MinMin.RequestBlocks", &args, &reply)
+		if err != nil || len(reply.Blocks) == 0 {
 			// Block not found, keep searching.
 			continue
 		}
-		// return the block
-		return blockMeta
+		for _, b := range reply.Blocks {
+			fetched[b.hash.ToString()] = b
+		}
+		if blockMeta, ok := fetched[hash.ToString()]; ok {
+			// return the block
+			return blockMeta
+		}
 	}
 
 	// Block not found.
@@ -944,7 +1793,18 @@ func crawlChainHelperGetBlock(hash blockartlib.Hash) (blockMeta *BlockMeta) {
 func validateBlock(chain []*BlockMeta) (err error) {
 	blockMeta := *chain[0]
 
-	// Verify hash.
+	// Verify version; it determines how the block is hashed.
+	if blockMeta.block.version != networkBlockVersion() {
+		return BlockVerificationError(blockMeta.hash.ToString())
+	}
+	// Verify length of the chain ending at this block.
+	if blockMeta.block.len != chain[1].block.len+1 {
+		return BlockVerificationError(blockMeta.hash.ToString())
+	}
+	// Verify hash; ops are covered by the hash through the Merkle root.
+	if opsMerkleRoot(blockMeta.block.version, blockMeta.block.ops).ToString() != blockMeta.block.merkleRoot.ToString() {
+		return blockartlib.InvalidBlockHashError(blockMeta.hash.ToString())
+	}
 	if hashBlock(blockMeta.block).ToString() != blockMeta.hash.ToString() {
 		return blockartlib.InvalidBlockHashError(blockMeta.hash.ToString())
 	}
@@ -952,6 +1812,10 @@ func validateBlock(chain []*BlockMeta) (err error) {
 	if err = verifyBlockNonce(hashBlock(blockMeta.block).ToString(), len(blockMeta.block.ops) == 0); err != nil {
 		return blockartlib.InvalidBlockHashError(blockMeta.hash.ToString())
 	}
+	// Verify timestamp.
+	if err = verifyBlockTimestamp(blockMeta.block, chain[1]); err != nil {
+		return err
+	}
 	// Verify block signature.
 	pub, err := hex.DecodeString(blockMeta.block.miner)
 	ownerPubKey, err := x509.ParsePKIXPublicKey(pub)
@@ -962,10 +1826,70 @@ func validateBlock(chain []*BlockMeta) (err error) {
 	if err = verifyOps(blockMeta); err != nil {
 		return err
 	}
+	// Verify the committed canvas state.
+	state := applyBlock(canvasStateAt(chain[1]), blockMeta.block)
+	if canvasStateRoot(blockMeta.block.version, state).ToString() != blockMeta.block.stateRoot.ToString() {
+		return BlockVerificationError(blockMeta.hash.ToString())
+	}
+	canvasStatesLock.Lock()
+	cacheCanvasState(&blockMeta, state)
+	canvasStatesLock.Unlock()
+
+	return nil
+}
 
+// Verifies that the block's timestamp is later than the median timestamp of the blocks preceding it,
+// and that it is not too far in the future.
+// - ASSUMES that parent and all of its ancestors are stored in blockTree
+// @param block Block: the block whose timestamp is verified
+// @param parent *BlockMeta: the block that block points to
+// @return err error: BlockTimestampError if the timestamp is invalid, nil otherwise
+func verifyBlockTimestamp(block Block, parent *BlockMeta) error {
+	if block.timestamp <= medianTimePast(parent) {
+		return BlockTimestampError(hashBlock(block).ToString())
+	}
+	if block.timestamp > time.Now().Add(maxBlockTimeDrift).UnixNano() {
+		return BlockTimestampError(hashBlock(block).ToString())
+	}
 	return nil
 }
 
+// Returns the median timestamp of the last medianTimeSpan blocks ending at blockMeta.
+// The genesis block has no timestamp, so it is not counted.
+// - ASSUMES that blockMeta and all of its ancestors are stored in blockTree
+// LOCKS: Acquires and releases blockTreeLock
+// @param blockMeta *BlockMeta: the most recent block to consider
+// @return int64: the median timestamp, or 0 if there are no blocks other than the genesis block
+func medianTimePast(blockMeta *BlockMeta) int64 {
+	blockTreeLock.Lock()
+	defer blockTreeLock.Unlock()
+
+	timestamps := []int64{}
+	curr := blockMeta
+	for curr != nil && !isGenesis(*curr) && len(timestamps) < medianTimeSpan {
+		timestamps = append(timestamps, curr.block.timestamp)
+		curr = blockTree[curr.block.prev.ToString()]
+	}
+
+	if len(timestamps) == 0 {
+		return 0
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// Returns the header of the block, which contains everything except the block's ops.
+// @param blockMeta *BlockMeta: the block whose header is returned
+// @return blockartlib.BlockHeader: the header of the block
+func blockHeader(blockMeta *BlockMeta) blockartlib.BlockHeader {
+	header := blockMeta.block.header()
+	header.Hash = blockMeta.hash.ToString()
+	header.R = new(big.Int).Set(&blockMeta.r)
+	header.S = new(big.Int).Set(&blockMeta.s)
+	return header
+}
+
 // Returns true if block is the genesis block.
 // @param block Block: The block to test against.
 // @return bool: True iff block is genesis block.
@@ -974,31 +1898,52 @@ func isGenesis(blockMeta BlockMeta) bool {
 	return block.prev.ToString() == "" && blockMeta.hash.ToString() == minerNetSettings.GenesisBlockHash
 }
 
-// Returns hash of block.
+// Returns the block version used by this network, as chosen by its genesis configuration.
+// @return uint8: the block version
+func networkBlockVersion() uint8 {
+	return blockartlib.EffectiveBlockVersion(minerNetSettings.BlockVersion)
+}
+
+// Returns hash of block, using the algorithm selected by the block's version.
+// Only the header is hashed; ops are covered by block.merkleRoot.
 // @param block Block: Block to be hashed.
 // @return Hash: The hash of the block.
 func hashBlock(block Block) blockartlib.Hash {
-	hasher := md5.New()
-	hasher.Write([]byte(block.ToString()))
-	return blockartlib.Hash(hasher.Sum(nil)[:])
+	return blockartlib.HashBlockHeader(block.header())
+}
+
+// Returns the Merkle root of the hashes of ops.
+// @param version uint8: the block version, which selects the hashing algorithm
+// @param ops []OpMeta: the ops, in block order
+// @return Hash: the Merkle root
+func opsMerkleRoot(version uint8, ops []OpMeta) blockartlib.Hash {
+	leaves := make([]blockartlib.Hash, len(ops))
+	for i, opMeta := range ops {
+		leaves[i] = opMeta.hash
+	}
+	return blockartlib.MerkleRoot(version, leaves)
 }
 
-// Returns hash of op.
+// Returns hash of op, using the algorithm selected by the network's block version.
+// The shape hash is committed to separately so that shape inclusion proofs can be checked by blockartlib.
 // @param op Op: Op to be hashed.
 // @return Hash: The hash of the op.
 func hashOp(op Op) blockartlib.Hash {
-	hasher := md5.New()
-	hasher.Write([]byte(op.ToString()))
-	return blockartlib.Hash(hasher.Sum(nil)[:])
+	return blockartlib.HashOp(networkBlockVersion(), op.shapeMeta.Hash, opContentHash(op))
+}
+
+// Returns hash of the op's contents, which hashOp combines with the hash of the op's shape.
+// @param op Op: Op to be hashed.
+// @return Hash: The hash of the op's contents.
+func opContentHash(op Op) blockartlib.Hash {
+	return blockartlib.HashBytes(networkBlockVersion(), []byte(op.ToString()))
 }
 
-// Returns hash of string.
+// Returns hash of string, using the algorithm selected by the network's block version.
 // @param s string: The string to hash.
 // @return []byte: The hash of the string.
 func hashString(s string) []byte {
-	hasher := md5.New()
-	hasher.Write([]byte(s))
-	return hasher.Sum(nil)[:]
+	return blockartlib.HashBytes(networkBlockVersion(), []byte(s))
 }
 
 // Verifies that hash meets POW requirements specified by server.
@@ -1009,8 +1954,7 @@ func verifyBlockNonce(hash string, noop bool) error {
 	if noop {
 		pow = minerNetSettings.PoWDifficultyNoOpBlock
 	}
-	n := int(pow)
-	if hash[len(hash)-n:] == strings.Repeat("0", n) {
+	if blockartlib.HasProofOfWork(hash, pow) {
 		return nil
 	}
 	return blockartlib.InvalidBlockHashError(hash)
@@ -1044,13 +1988,27 @@ func verifyOps(blockMeta BlockMeta) error {
 // @param candidateOp Op: The op to verify.
 // @param blockMeta *Block: The starting blockMeta on which to begin the verification.
 //							Note: this does not need to be a fully valid block; it can also be a pseudo block meta
-//                                created around currBlock
+//                                holding pending ops (see pendingBlockMeta)
 // @param ch chan<-error: The channel to which verification errors are piped into, or nil if no errors are found.
 func verifyOp(candidateOpMeta OpMeta, blockMeta *BlockMeta, indexInBlock int, ch chan<- error) {
+	// Everything but the hash and signature is checked against the canvas as it is just before the op.
+	state := canvasStateForOp(blockMeta, indexInBlock)
+	if state == nil {
+		ch <- blockartlib.InvalidBlockHashError(blockMeta.block.prev.ToString())
+		return
+	}
+	ch <- checkOp(candidateOpMeta, state)
+}
+
+// Verifies an op as verifyOp does, but against a state the caller already has, so that ops checked one
+// after another can share a state kept up to date with applyOp.
+// @param candidateOpMeta OpMeta: the op to verify
+// @param state *canvasState: the canvas state just before the op
+// @return error: nil iff valid.
+func checkOp(candidateOpMeta OpMeta, state *canvasState) error {
 	// Verify hash.
 	if hashOp(candidateOpMeta.op).ToString() != candidateOpMeta.hash.ToString() {
-		ch <- blockartlib.InvalidShapeHashError(candidateOpMeta.hash.ToString())
-		return
+		return blockartlib.InvalidShapeHashError(candidateOpMeta.hash.ToString())
 	}
 
 	candidateOp := candidateOpMeta.op
@@ -1059,174 +2017,426 @@ func verifyOp(candidateOpMeta OpMeta, blockMeta *BlockMeta, indexInBlock int, ch
 	pub, _ := hex.DecodeString(candidateOpMeta.op.owner)
 	ownerPubKey, _ := x509.ParsePKIXPublicKey(pub)
 	if !ecdsa.Verify(ownerPubKey.(*ecdsa.PublicKey), candidateOpMeta.hash, &candidateOpMeta.r, &candidateOpMeta.s) {
-		ch <- blockartlib.InvalidShapeHashError(candidateOpMeta.hash.ToString())
-		return
+		return blockartlib.InvalidShapeHashError(candidateOpMeta.hash.ToString())
 	}
 
-	if candidateOp.deleteShapeHash == "" {
+	switch candidateOp.kind {
+	case ADD_OP:
 		// Verify shape.
-		if err := validateShape(&candidateOp.shapeMeta); err != nil {
-			ch <- err
-			return
+		if err := verifyNewShape(&candidateOp.shapeMeta, candidateOp.owner, state); err != nil {
+			return err
 		}
-
-		// Verify op with shape.
 		shape := candidateOp.shapeMeta.Shape
-		// Ensure svg string isn't beyond the maximum specified length.
-		if svg := shape.Svg; blockartlib.IsSvgTooLong(svg) {
-			ch <- blockartlib.ShapeSvgStringTooLongError(svg)
-			return
-		}
 
-		// Ensure shape is on the canvas.
-		if !IsShapeInCanvas(shape) {
-			ch <- blockartlib.OutOfBoundsError{}
-			return
+		// Ensure miner has enough ink for the shape and the fee.
+		ink := state.ink[candidateOp.owner]
+		if uint64(ink) < uint64(shape.Ink)+uint64(candidateOp.fee) {
+			return blockartlib.InsufficientInkError(ink)
 		}
 
-		// Ensure miner has enough ink.
-		var ink uint32
-		if indexInBlock >= 0 {
-			// op is in the block, so don't double count the ink it uses
-			ink = inkAvail(candidateOp.owner, blockTree[blockMeta.block.prev.ToString()])
-			ink += shape.Ink
-		} else {
-			// if indexInBlock == -1, this is a new op
-			ink = inkAvailCurr()
+		// Ensure op is not duplicate.
+		if _, live := state.shapes[candidateOp.shapeMeta.Hash]; live || state.ops[candidateOpMeta.hash.ToString()] {
+			return blockartlib.OutOfBoundsError{}
 		}
 
-		if ink < shape.Ink {
-			ch <- blockartlib.InsufficientInkError(ink)
-			return
+		// Ensure shape does not overlap with shapes on the canvas owned by someone else.
+		if err := verifyNoOverlap(candidateOp.shapeMeta, candidateOp.owner, state, ""); err != nil {
+			return err
 		}
 
-		// Ensure op is not duplicate and shape does not overlap with other ops in the chain.
-		curr := blockMeta
-		for {
-			for i, opMeta := range curr.block.ops {
-				// test if curr == blockMeta
-				// aren't guaranteed blockMeta is a valid meta, just use block
-				if curr.block.prev.ToString() == blockMeta.block.prev.ToString() && i == indexInBlock {
-					// this is the op itself in the block; skip it
-					continue
-				}
+		// Ensure shape is not in a region claimed by someone else.
+		if err := verifyNotClaimed(candidateOp.shapeMeta.Shape, candidateOp.owner, state); err != nil {
+			return err
+		}
+	case DELETE_OP:
+		// Ensure miner has enough ink for the fee; the shape's refund only arrives once the op is applied.
+		if ink := state.ink[candidateOp.owner]; ink < candidateOp.fee {
+			return blockartlib.InsufficientInkError(ink)
+		}
 
-				// This op has been performed before.
-				if candidateOpMeta.hash.ToString() == opMeta.hash.ToString() {
-					ch <- blockartlib.OutOfBoundsError{}
-					return
-				}
+		// Ensure shape is on the canvas, and currently belongs to this miner.
+		if shape, live := state.shapes[candidateOp.deleteShapeHash]; !live || shape.owner != candidateOp.owner {
+			return blockartlib.ShapeOwnerError(candidateOp.deleteShapeHash)
+		}
+	case UPDATE_OP:
+		// Ensure the old shape is on the canvas, and currently belongs to this miner.
+		oldShape, live := state.shapes[candidateOp.deleteShapeHash]
+		if !live || oldShape.owner != candidateOp.owner {
+			return blockartlib.ShapeOwnerError(candidateOp.deleteShapeHash)
+		}
 
-				if candidateOpMeta.op.owner != opMeta.op.owner {
-					if blockartlib.ShapesIntersect(shape, opMeta.op.shapeMeta.Shape, minerNetSettings.CanvasSettings) {
-						ch <- blockartlib.ShapeOverlapError(candidateOpMeta.op.shapeMeta.Hash)
-						return
-					}
-				}
-			}
+		// Verify the new shape, which must stay on the old shape's canvas.
+		if err := verifyNewShape(&candidateOp.shapeMeta, candidateOp.owner, state); err != nil {
+			return err
+		}
+		if candidateOp.shapeMeta.Shape.Canvas != oldShape.shape.Canvas {
+			return blockartlib.InvalidCanvasError(candidateOp.shapeMeta.Shape.Canvas)
+		}
+		shape := candidateOp.shapeMeta.Shape
 
-			// Exit loop once we verify no overlap conflicts in the genesis block.
-			if isGenesis(*curr) {
-				break
-			}
+		// Ensure miner has enough ink for the new shape and the fee, given the old shape's refund.
+		ink := state.ink[candidateOp.owner]
+		if uint64(ink)+uint64(oldShape.ink) < uint64(shape.Ink)+uint64(candidateOp.fee) {
+			return blockartlib.InsufficientInkError(ink)
+		}
 
-			var ok bool
-			curr, ok = blockTree[curr.block.prev.ToString()]
-			if !ok {
-				ch <- blockartlib.InvalidBlockHashError(curr.block.prev.ToString())
-				return
-			}
+		// Ensure op is not duplicate.
+		if _, live := state.shapes[candidateOp.shapeMeta.Hash]; live || state.ops[candidateOpMeta.hash.ToString()] {
+			return blockartlib.OutOfBoundsError{}
 		}
-	} else {
-		// op is a delete; verify shape existed on the canvas, and belonged to this miner
-		curr := blockMeta
-		for {
-			for i, opMeta := range curr.block.ops {
-				// only want to search through ops that appear *before* this op, so if i == -1, that's all ops
-				// and if i >= 0, that's all ops with a *smaller* index
-				// test if curr == blockMeta, and that the
-				// aren't guaranteed blockMeta is a valid meta, just use block
-				if curr.block.prev.ToString() == blockMeta.block.prev.ToString() && indexInBlock >= 0 && i >= indexInBlock {
-					// this op is after candidateOpMeta
-					continue
-				}
 
-				// check duplicate
-				if opMeta.hash.ToString() == candidateOpMeta.hash.ToString() {
-					// op is a duplicate; this is an error
-					ch <- blockartlib.ShapeOwnerError(candidateOp.deleteShapeHash)
-					return
-				}
+		// Ensure the new shape does not overlap with shapes owned by someone else; the old shape is going away.
+		if err := verifyNoOverlap(candidateOp.shapeMeta, candidateOp.owner, state, candidateOp.deleteShapeHash); err != nil {
+			return err
+		}
 
-				if opMeta.op.deleteShapeHash == "" && opMeta.op.shapeMeta.Hash == candidateOpMeta.op.deleteShapeHash {
-					// found the op for adding the shape
-					if opMeta.op.owner == candidateOp.owner {
-						// correct owner
-						ch <- nil
-						return
-					}
+		// Ensure the new shape is not in a region claimed by someone else.
+		if err := verifyNotClaimed(candidateOp.shapeMeta.Shape, candidateOp.owner, state); err != nil {
+			return err
+		}
+	case TRANSFER_OP:
+		if err := verifyTransfer(candidateOpMeta, state); err != nil {
+			return err
+		}
+	case TRANSFER_SHAPE_OP:
+		if err := verifyShapeTransfer(candidateOpMeta, state); err != nil {
+			return err
+		}
+	case CLAIM_OP:
+		if err := verifyClaim(candidateOpMeta, state); err != nil {
+			return err
+		}
+	case GRANT_OP:
+		if err := verifyGrant(candidateOpMeta, state); err != nil {
+			return err
+		}
+	case CREATE_CANVAS_OP:
+		if err := verifyCreateCanvas(candidateOp, state); err != nil {
+			return err
+		}
+	default:
+		// unknown kind of op
+		return blockartlib.InvalidShapeHashError(candidateOpMeta.hash.ToString())
+	}
 
-					// incorrect owner
-					ch <- blockartlib.ShapeOwnerError(candidateOp.deleteShapeHash)
-					return
-				}
-			}
+	return nil
+}
 
-			// Exit loop once we verify no overlap conflicts in the genesis block.
-			if isGenesis(*curr) {
-				break
-			}
+// Verifies a shape drawn by an add or update op: it matches its hash and svg path, its svg string isn't
+// too long, its canvas exists and its rules let owner draw the shape, and it is within the canvas.
+// @param shapeMeta *blockartlib.ShapeMeta: the shape to verify
+// @param owner string: public key of the miner drawing the shape
+// @param state *canvasState: the canvas state the shape is drawn on
+// @return error: nil iff valid
+func verifyNewShape(shapeMeta *blockartlib.ShapeMeta, owner string, state *canvasState) error {
+	if err := validateShape(shapeMeta); err != nil {
+		return err
+	}
 
-			var ok bool
-			curr, ok = blockTree[curr.block.prev.ToString()]
-			if !ok {
-				ch <- blockartlib.InvalidBlockHashError(curr.block.prev.ToString())
-				return
-			}
-		}
+	shape := shapeMeta.Shape
+	// Ensure svg string isn't beyond the maximum specified length.
+	if svg := shape.Svg; blockartlib.IsSvgTooLong(svg) {
+		return blockartlib.ShapeSvgStringTooLongError(svg)
+	}
+	if transform := shape.Transform; blockartlib.IsSvgTooLong(transform) {
+		return blockartlib.ShapeSvgStringTooLongError(transform)
+	}
 
-		// could not find shape
-		ch <- blockartlib.ShapeOwnerError(candidateOp.deleteShapeHash)
-		return
+	// Ensure the shape's canvas exists, and its rules allow the shape.
+	canvas, ok := canvasFor(state, shape.Canvas)
+	if !ok {
+		return blockartlib.InvalidCanvasError(shape.Canvas)
+	}
+	if canvas.rules.CreatorOnly && owner != canvas.creator {
+		return blockartlib.InvalidCanvasError(shape.Canvas)
+	}
+	if canvas.rules.MaxShapeInk != 0 && shape.Ink > canvas.rules.MaxShapeInk {
+		return blockartlib.InvalidCanvasError(shape.Canvas)
+	}
+	if shape.Layer > canvas.rules.MaxLayer {
+		return blockartlib.InvalidCanvasError(shape.Canvas)
+	}
+
+	// Ensure shape is on the canvas.
+	if !IsShapeInCanvas(shape, canvas.settings) {
+		return blockartlib.OutOfBoundsError{}
 	}
 
-	ch <- nil
-	return
+	return nil
 }
 
-/*
-	I know it's bad to copy, but just trying to get it to work
-	Check if all the edges in the shape are within the campus
-	@param: takes a shape assembled from the svg string, checks the list of edges' absolute points
-	@return: boolean if all edges are within the canvas
-*/
-func IsShapeInCanvas(shape blockartlib.Shape) bool {
-	canvasXMax := float64(minerNetSettings.CanvasSettings.CanvasXMax)
-	canvasYMax := float64(minerNetSettings.CanvasSettings.CanvasYMax)
-	for _, edge := range shape.Edges {
-		if edge.Start.X < 0 || edge.Start.Y < 0 || edge.End.X < 0 || edge.End.Y < 0 {
-			return false
+// Verifies that a shape does not overlap with any shape on the same canvas and layer owned by someone else.
+// @param shapeMeta blockartlib.ShapeMeta: the shape being drawn
+// @param owner string: public key of the miner drawing the shape
+// @param state *canvasState: the canvas the shape is drawn on
+// @param ignoreHash string: hash of a shape on the canvas to skip, or empty
+// @return error: ShapeOverlapError, nil iff there is no overlap
+func verifyNoOverlap(shapeMeta blockartlib.ShapeMeta, owner string, state *canvasState, ignoreHash string) error {
+	canvas, _ := canvasFor(state, shapeMeta.Shape.Canvas)
+	settings := canvas.settings
+	// each canvas, and each layer within it, is checked for overlaps on its own, and only shapes near
+	// the shape can overlap it
+	shape := shapeMeta.Shape
+	for _, hash := range state.index.near(shape.Canvas, shape.Layer, blockartlib.ShapeBounds(shape)) {
+		other := state.shapes[hash]
+		if hash == ignoreHash || other.owner == owner {
+			continue
 		}
+		if blockartlib.ShapesIntersect(shapeMeta.Shape, other.shape, settings) {
+			return blockartlib.ShapeOverlapError(shapeMeta.Hash)
+		}
+	}
+	return nil
+}
 
-		if !floatEquals(edge.Start.X, canvasXMax) && edge.Start.X > canvasXMax {
+// Checks if a box on a layer of a canvas touches no shape of anyone but owner, and no region claimed by
+// anyone who hasn't granted owner the right to draw in it; any shape owner draws inside the box is then valid
+// as far as overlaps and claims go.
+// @param box blockartlib.Box: the box, including its border
+// @param canvasID string: ID of the canvas
+// @param layer uint8: the layer
+// @param owner string: public key of the miner that would draw in the box
+// @param state *canvasState: the canvas state
+// @return bool
+func isBoxFree(box blockartlib.Box, canvasID string, layer uint8, owner string, state *canvasState) bool {
+	canvas, _ := canvasFor(state, canvasID)
+	for _, hash := range state.index.near(canvasID, layer, box) {
+		other := state.shapes[hash]
+		if other.owner != owner && blockartlib.ShapeInRegion(other.shape, box, canvas.settings) {
 			return false
 		}
-		if !floatEquals(edge.Start.Y, canvasYMax) && edge.Start.Y > canvasYMax {
-			return false
+	}
+
+	// a filled shape covering the box is claimed iff the box is
+	corners := []blockartlib.Point{
+		{X: box.MinX, Y: box.MinY}, {X: box.MaxX, Y: box.MinY}, {X: box.MaxX, Y: box.MaxY}, {X: box.MinX, Y: box.MaxY},
+	}
+	boxShape := blockartlib.Shape{FilledIn: true, Canvas: canvasID, Layer: layer}
+	for i := range corners {
+		boxShape.Edges = append(boxShape.Edges, blockartlib.Edge{Start: corners[i], End: corners[(i+1)%len(corners)]})
+	}
+	return verifyNotClaimed(boxShape, owner, state) == nil
+}
+
+// Verifies a transfer op: the amount is positive, the recipient is a valid public key other than the
+// owner's, the owner has enough ink for the amount and the fee, and the op is not already on the chain.
+// Takes the op, and the canvas state just before the op.
+// @return error: InvalidTransferError or InsufficientInkError; nil iff valid.
+func verifyTransfer(candidateOpMeta OpMeta, state *canvasState) error {
+	op := candidateOpMeta.op
+	if op.transferAmount == 0 || op.transferTo == op.owner || !isPublicKey(op.transferTo) {
+		return blockartlib.InvalidTransferError(op.transferTo)
+	}
+
+	ink := state.ink[op.owner]
+	if uint64(ink) < uint64(op.transferAmount)+uint64(op.fee) {
+		return blockartlib.InsufficientInkError(ink)
+	}
+
+	// Ensure the op is not being replayed.
+	if state.ops[candidateOpMeta.hash.ToString()] {
+		return blockartlib.InvalidTransferError(op.transferTo)
+	}
+
+	return nil
+}
+
+// Verifies a shape transfer op: the shape is on the canvas and belongs to the owner, the recipient is a
+// valid public key other than the owner's, the shape does not overlap any other shape of the owner's,
+// the owner has enough ink for the fee, and the op is not already on the chain.
+// Takes the op, and the canvas state just before the op.
+// @return error: ShapeOwnerError, InvalidTransferError, ShapeOverlapError or InsufficientInkError; nil iff valid.
+func verifyShapeTransfer(candidateOpMeta OpMeta, state *canvasState) error {
+	op := candidateOpMeta.op
+	shape, live := state.shapes[op.transferShapeHash]
+	if !live || shape.owner != op.owner {
+		return blockartlib.ShapeOwnerError(op.transferShapeHash)
+	}
+	if op.transferTo == op.owner || !isPublicKey(op.transferTo) {
+		return blockartlib.InvalidTransferError(op.transferTo)
+	}
+
+	// Ensure the shape, once it is the recipient's, does not overlap with shapes owned by someone else;
+	// the owner's own shapes were allowed to overlap it until now.
+	shapeMeta := blockartlib.ShapeMeta{Hash: op.transferShapeHash, Shape: shape.shape}
+	if err := verifyNoOverlap(shapeMeta, op.transferTo, state, op.transferShapeHash); err != nil {
+		return err
+	}
+
+	if ink := state.ink[op.owner]; ink < op.fee {
+		return blockartlib.InsufficientInkError(ink)
+	}
+
+	// Ensure the op is not being replayed, e.g. after the shape was handed back.
+	if state.ops[candidateOpMeta.hash.ToString()] {
+		return blockartlib.ShapeOwnerError(op.transferShapeHash)
+	}
+
+	return nil
+}
+
+// Verifies that a shape drawn by owner does not touch the region of an active claim on its canvas that is
+// neither owner's nor granted to owner.
+// @param shape blockartlib.Shape: the shape being drawn
+// @param owner string: public key of the miner drawing the shape
+// @param state *canvasState: the canvas the shape is drawn on
+// @return error: RegionClaimedError, nil iff the shape is not in someone else's claim
+func verifyNotClaimed(shape blockartlib.Shape, owner string, state *canvasState) error {
+	canvas, _ := canvasFor(state, shape.Canvas)
+	for hash, claim := range state.claims {
+		if claim.canvas != shape.Canvas || claim.owner == owner || claim.grantees[owner] {
+			continue
 		}
-		if !floatEquals(edge.End.X, canvasXMax) && edge.End.X > canvasXMax {
-			return false
+		if blockartlib.ShapeInRegion(shape, claim.region, canvas.settings) {
+			return blockartlib.RegionClaimedError(hash)
 		}
-		if !floatEquals(edge.End.Y, canvasYMax) && edge.End.Y > canvasYMax {
+	}
+	return nil
+}
+
+// Verifies a claim op: the canvas exists, the region is on the canvas and not empty, the claim lasts for between 1 and
+// maxClaimBlocks blocks, the deposit is at least the region's area, the owner has enough ink for the
+// deposit and the fee, the region does not overlap anyone else's active claim, and the op is not
+// already on the chain.
+// Takes the op, and the canvas state just before the op.
+// @return error: InvalidClaimError, RegionClaimedError or InsufficientInkError; nil iff valid.
+func verifyClaim(candidateOpMeta OpMeta, state *canvasState) error {
+	op := candidateOpMeta.op
+	region := op.claimRegion
+	def, ok := canvasFor(state, op.canvasID)
+	if !ok {
+		return blockartlib.InvalidCanvasError(op.canvasID)
+	}
+	canvas := def.settings
+	if region.MinX < 0 || region.MinY < 0 || region.MaxX > float64(canvas.CanvasXMax) || region.MaxY > float64(canvas.CanvasYMax) ||
+		region.MinX >= region.MaxX || region.MinY >= region.MaxY {
+		return blockartlib.InvalidClaimError("")
+	}
+	if op.claimBlocks == 0 || op.claimBlocks > maxClaimBlocks {
+		return blockartlib.InvalidClaimError("")
+	}
+	if area := (region.MaxX - region.MinX) * (region.MaxY - region.MinY); float64(op.deposit) < math.Ceil(area) {
+		return blockartlib.InvalidClaimError("")
+	}
+
+	ink := state.ink[op.owner]
+	if uint64(ink) < uint64(op.deposit)+uint64(op.fee) {
+		return blockartlib.InsufficientInkError(ink)
+	}
+
+	for hash, claim := range state.claims {
+		if claim.canvas == op.canvasID && claim.owner != op.owner && blockartlib.RegionsOverlap(region, claim.region) {
+			return blockartlib.RegionClaimedError(hash)
+		}
+	}
+
+	// Ensure the op is not being replayed.
+	if state.ops[candidateOpMeta.hash.ToString()] {
+		return blockartlib.InvalidClaimError(candidateOpMeta.hash.ToString())
+	}
+
+	return nil
+}
+
+// Verifies a grant op: the claim is active and was made by the owner, the grantee is a valid public key
+// other than the owner's, the owner has enough ink for the fee, and the op is not already on the chain.
+// Takes the op, and the canvas state just before the op.
+// @return error: InvalidClaimError, InvalidTransferError or InsufficientInkError; nil iff valid.
+func verifyGrant(candidateOpMeta OpMeta, state *canvasState) error {
+	op := candidateOpMeta.op
+	if claim, active := state.claims[op.claimHash]; !active || claim.owner != op.owner {
+		return blockartlib.InvalidClaimError(op.claimHash)
+	}
+	if op.transferTo == op.owner || !isPublicKey(op.transferTo) {
+		return blockartlib.InvalidTransferError(op.transferTo)
+	}
+
+	if ink := state.ink[op.owner]; ink < op.fee {
+		return blockartlib.InsufficientInkError(ink)
+	}
+
+	// Ensure the op is not being replayed.
+	if state.ops[candidateOpMeta.hash.ToString()] {
+		return blockartlib.InvalidClaimError(op.claimHash)
+	}
+
+	return nil
+}
+
+// Verifies a create canvas op: the ID is made of letters, digits, '-' and '_', is at most maxCanvasIDLength
+// long and is not taken, the canvas's dimensions are between 1 and maxCanvasDimension, and the owner has
+// enough ink for the fee.
+// @param op Op: the op to verify
+// @param state *canvasState: the canvas state just before the op
+// @return error: InvalidCanvasError or InsufficientInkError; nil iff valid.
+func verifyCreateCanvas(op Op, state *canvasState) error {
+	if !isCanvasID(op.canvasID) {
+		return blockartlib.InvalidCanvasError(op.canvasID)
+	}
+	if _, exists := state.canvases[op.canvasID]; exists {
+		return blockartlib.InvalidCanvasError(op.canvasID)
+	}
+
+	settings := op.canvasSettings
+	if settings.CanvasXMax == 0 || settings.CanvasYMax == 0 ||
+		settings.CanvasXMax > maxCanvasDimension || settings.CanvasYMax > maxCanvasDimension {
+		return blockartlib.InvalidCanvasError(op.canvasID)
+	}
+
+	if ink := state.ink[op.owner]; ink < op.fee {
+		return blockartlib.InsufficientInkError(ink)
+	}
+
+	return nil
+}
+
+// Returns true iff s can be the ID of a created canvas; IDs end up in svg strings, so they are kept plain.
+func isCanvasID(s string) bool {
+	if s == "" || len(s) > maxCanvasIDLength {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
 			return false
 		}
 	}
 	return true
 }
 
-// Again copied, sorry
-func floatEquals(a, b float64) bool {
-	return (a-b) < blockartlib.EPSILON && (b-a) < blockartlib.EPSILON
+// Returns true iff s is the hex encoding of an ecdsa public key, as miners identify each other.
+func isPublicKey(s string) bool {
+	pub, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	key, err := x509.ParsePKIXPublicKey(pub)
+	if err != nil {
+		return false
+	}
+	_, ok := key.(*ecdsa.PublicKey)
+	return ok
+}
+
+/*
+	I know it's bad to copy, but just trying to get it to work
+	Check if all the edges in the shape are within the campus
+	@param: takes a shape assembled from the svg string, checks the list of edges' absolute points
+	@return: boolean if all edges are within the canvas
+*/
+func IsShapeInCanvas(shape blockartlib.Shape, settings blockartlib.CanvasSettings) bool {
+	canvasXMax := float64(settings.CanvasXMax)
+	canvasYMax := float64(settings.CanvasYMax)
+	// the comparisons are exact, and written so that NaN is out of the canvas
+	inRange := func(value float64, max float64) bool {
+		return 0 <= value && value <= max
+	}
+	for _, edge := range shape.Edges {
+		if !inRange(edge.Start.X, canvasXMax) || !inRange(edge.Start.Y, canvasYMax) ||
+			!inRange(edge.End.X, canvasXMax) || !inRange(edge.End.Y, canvasYMax) {
+			return false
+		}
+	}
+	return true
 }
 
 // Sends op to all neighbours.
@@ -1284,38 +2494,41 @@ func floodBlock(blockMeta BlockMeta) {
 
 // Should be called whenever a new op is received, either from a blockartlib or another miner
 // This functions:
-// - validates the op
-// - if valid, then adds the op to the currBlock, and then floods the op to other miners
+// - validates the op against the chain and the ops already in the mempool
+// - if valid, then adds the op to the mempool, and then floods the op to other miners
 // Returned error is nil if op is valid.
 // @param opMeta OpMeta: Op to be validated.
 // @return err error: nil if op is valid; otherwise can return one of the following errors:
 //  	- InsufficientInkError
 // 		- ShapeOverlapError
 // 		- OutOfBoundsError
+// 		- MempoolFullError
+// 		- RegionClaimedError
 func receiveNewOp(opMeta OpMeta) (err error) {
-	// acquire currBlock's lock
+	// acquire the mempool's lock
 	blockLock.Lock()
 	defer blockLock.Unlock()
 
-	for _, curOpMeta := range currBlock.ops {
-		if opMeta.hash.ToString() == curOpMeta.hash.ToString() {
-			// already aware of this op
-			return nil
-		}
+	if pool.has(opMeta.hash.ToString()) {
+		// already aware of this op
+		return nil
 	}
+	expireMempool(time.Now())
 
 	// check if op is valid
 	verifyCh := make(chan error, 1)
-	pseudoCurrBlockMeta := BlockMeta{block: *currBlock}
-	go verifyOp(opMeta, &pseudoCurrBlockMeta, -1, verifyCh)
+	pseudoBlockMeta := pendingBlockMeta()
+	go verifyOp(opMeta, &pseudoBlockMeta, -1, verifyCh)
 
 	err = <-verifyCh
 	if err != nil {
 		return err
 	}
 
-	// op is valid; add op to currBlock
-	currBlock.ops = append(currBlock.ops, opMeta)
+	// op is valid; add op to the mempool
+	if err = pool.add(&mempoolEntry{opMeta: opMeta, received: time.Now()}); err != nil {
+		return err
+	}
 
 	// floodOp on a separate thread; this miner's operation doesn't depend on the flood
 	go floodOp(opMeta)
@@ -1332,15 +2545,37 @@ func validateShape(candidateShapeMeta *blockartlib.ShapeMeta) (err error) {
 	candidateShape := candidateShapeMeta.Shape
 
 	// Ensure hash is correct.
-	if candidateShapeMeta.Hash != blockartlib.HashShape(candidateShape) {
+	if candidateShapeMeta.Hash != blockartlib.HashShape(candidateShape, networkBlockVersion()) {
 		return blockartlib.OutOfBoundsError{}
 	}
 
-	// Ensure shape properties correspond to the svg path.
-	shape, err := blockartlib.ParseSvgPath(candidateShape.Svg)
+	// Ensure fill and stroke are colors in canonical form, and the shape is filled iff its fill isn't transparent.
+	for _, color := range []string{candidateShape.FillColor, candidateShape.BorderColor} {
+		if normalized, err := blockartlib.NormalizeColor(color); err != nil || normalized != color {
+			return blockartlib.InvalidColorError(color)
+		}
+	}
+	if candidateShape.FilledIn != (candidateShape.FillColor != blockartlib.TRANSPARENT) {
+		return blockartlib.OutOfBoundsError{}
+	}
+
+	// Ensure shape properties correspond to the svg path, or the text, moved by the shape's transform.
+	var shape *blockartlib.Shape
+	if candidateShape.IsText {
+		if candidateShape.FilledIn {
+			return blockartlib.InvalidShapeSvgStringError(candidateShape.Svg)
+		}
+		shape, err = blockartlib.ParseSvgText(candidateShape.Svg)
+	} else {
+		shape, err = blockartlib.ParseSvgPath(candidateShape.Svg)
+	}
 	if err != nil {
 		return err
 	}
+	shape.Transform = candidateShape.Transform
+	if err := blockartlib.ApplyTransform(shape); err != nil {
+		return err
+	}
 
 	if len(shape.Edges) != len(candidateShape.Edges) {
 		return blockartlib.OutOfBoundsError{}
@@ -1357,8 +2592,14 @@ func validateShape(candidateShapeMeta *blockartlib.ShapeMeta) (err error) {
 		}
 	}
 
+	// Ensure a path that is only stroked doesn't cross itself. Filled paths may, as their fill rule decides
+	// which of the parts they enclose are inside them, and so do text, whose letters' strokes meet.
+	if !candidateShape.FilledIn && !candidateShape.IsText && !blockartlib.IsSimpleShape(shape) {
+		return blockartlib.OutOfBoundsError{}
+	}
+
 	// Ensure accuracy of Ink parameter.
-	ink, err := blockartlib.InkUsed(&candidateShape)
+	ink, err := blockartlib.InkUsedWithPricing(&candidateShape, minerNetSettings.InkPricing)
 	if err != nil {
 		return err
 	}
@@ -1367,167 +2608,713 @@ func validateShape(candidateShapeMeta *blockartlib.ShapeMeta) (err error) {
 		return blockartlib.OutOfBoundsError{}
 	}
 
-	if !blockartlib.IsSimpleShape(shape) {
-		return blockartlib.OutOfBoundsError{}
+	return nil
+}
+
+///////////////////////////////////////////////////////////
+/* Structs and helper functions for the mempool          */
+///////////////////////////////////////////////////////////
+
+// An op waiting to be put into a block.
+type mempoolEntry struct {
+	opMeta   OpMeta
+	received time.Time // when this miner first saw the op; it expires mempoolOpExpiry later.
+}
+
+// The ops that are valid on the longest chain but are not in a block on it yet.
+// Each op is valid given the chain and the ops before it in the mempool.
+// - All access must hold blockLock
+type mempool struct {
+	entries  []*mempoolEntry          // in the order the ops were received.
+	byHash   map[string]*mempoolEntry // keyed by op hash.
+	perOwner map[string]int           // number of ops in the mempool, keyed by public key of the owner.
+	changed  bool                     // whether ops have been added or removed since the last block template.
+}
+
+func newMempool() *mempool {
+	return &mempool{byHash: make(map[string]*mempoolEntry), perOwner: make(map[string]int)}
+}
+
+// Returns true iff the op with the passed hash is in the mempool.
+func (p *mempool) has(hash string) bool {
+	_, ok := p.byHash[hash]
+	return ok
+}
+
+// Adds entry to the end of the mempool.
+// - ASSUMES that entry.opMeta is valid given the chain and the ops already in the mempool
+// @param entry *mempoolEntry: the op and when it was received
+// @return error: MempoolFullError if the mempool, or the owner's share of it, is full
+func (p *mempool) add(entry *mempoolEntry) error {
+	owner := entry.opMeta.op.owner
+	if len(p.entries) >= maxMempoolOps {
+		return blockartlib.MempoolFullError("")
+	}
+	if p.perOwner[owner] >= maxMempoolOpsPerOwner {
+		return blockartlib.MempoolFullError(owner)
 	}
 
+	p.entries = append(p.entries, entry)
+	p.byHash[entry.opMeta.hash.ToString()] = entry
+	p.perOwner[owner]++
+	p.changed = true
 	return nil
 }
 
+// Returns the ops in the mempool, in the order they were received.
+func (p *mempool) ops() []OpMeta {
+	ops := make([]OpMeta, len(p.entries))
+	for i, entry := range p.entries {
+		ops[i] = entry.opMeta
+	}
+	return ops
+}
+
+// Returns the ops in the mempool in the order they should be put into blocks, highest priority first.
+// Ops offering a higher fee go first; among ops with the same fee, those that have waited longest go first.
+func (p *mempool) byPriority() []OpMeta {
+	entries := append([]*mempoolEntry{}, p.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].opMeta.op.fee != entries[j].opMeta.op.fee {
+			return entries[i].opMeta.op.fee > entries[j].opMeta.op.fee
+		}
+		return entries[i].received.Before(entries[j].received)
+	})
+
+	ops := make([]OpMeta, len(entries))
+	for i, entry := range entries {
+		ops[i] = entry.opMeta
+	}
+	return ops
+}
+
+// Empties the mempool, and then adds back, in order, each of candidates that is not in onChain and is
+// still valid given the chain ending at headBlockMeta and the candidates added back before it.
+// - ASSUMES that blockLock has been acquired
+// @param candidates []*mempoolEntry: ops to add back
+// @param onChain map[string]bool: hashes of ops that are already on the chain, and are skipped
+// @return []OpMeta: the ops that were added back
+func refillMempool(candidates []*mempoolEntry, onChain map[string]bool) []OpMeta {
+	pool = newMempool()
+	pool.changed = true
+
+	// each op is checked against the chain and the ops added back before it, which are applied to state as
+	// they are added
+	pending := &BlockMeta{block: Block{prev: currBlock.prev, len: currBlock.len}}
+	state := canvasStateForOp(pending, -1)
+	if state == nil {
+		return pool.ops()
+	}
+	for _, entry := range candidates {
+		if onChain[entry.opMeta.hash.ToString()] || pool.has(entry.opMeta.hash.ToString()) {
+			continue
+		}
+		if checkOp(entry.opMeta, state) != nil {
+			continue
+		}
+		// op is still valid; it can only fail to be added if the limits are hit
+		if pool.add(entry) != nil {
+			continue
+		}
+		pending.block.ops = append(pending.block.ops, entry.opMeta)
+		applyOp(state, entry.opMeta, "", pending.block.len)
+	}
+
+	return pool.ops()
+}
+
+// Drops ops that have been in the mempool for longer than mempoolOpExpiry, along with any ops that
+// are no longer valid without them.
+// - ASSUMES that blockLock has been acquired
+// @param now time.Time: the current time
+func expireMempool(now time.Time) {
+	kept := []*mempoolEntry{}
+	for _, entry := range pool.entries {
+		if now.Sub(entry.received) <= mempoolOpExpiry {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(pool.entries) {
+		return
+	}
+
+	expired := len(pool.entries) - len(kept)
+	refillMempool(kept, nil)
+	fmt.Printf("Mempool: %d ops expired, %d ops pending\n", expired, len(pool.entries))
+}
+
+// Returns the ops for the next block: the mempool's ops by priority, each kept only if it is valid given
+// the chain and the ops chosen before it, up to maxOpsPerBlock.
+// - ASSUMES that blockLock has been acquired
+// @return []OpMeta: the ops, in the order they go into the block
+func blockTemplate() []OpMeta {
+	// as in refillMempool, the ops chosen are applied to state as they are chosen
+	template := &BlockMeta{block: Block{prev: currBlock.prev, len: currBlock.len}}
+	state := canvasStateForOp(template, -1)
+	if state == nil {
+		return template.block.ops
+	}
+	for _, opMeta := range pool.byPriority() {
+		if len(template.block.ops) == maxOpsPerBlock {
+			break
+		}
+
+		if checkOp(opMeta, state) == nil {
+			template.block.ops = append(template.block.ops, opMeta)
+			applyOp(state, opMeta, "", template.block.len)
+		}
+	}
+
+	return template.block.ops
+}
+
+// Returns a pseudo block meta on top of the head block holding all of the mempool's ops, for
+// checking new ops against everything that is pending.
+// - ASSUMES that blockLock has been acquired
+func pendingBlockMeta() BlockMeta {
+	return BlockMeta{block: Block{prev: currBlock.prev, len: currBlock.len, ops: pool.ops()}}
+}
+
 ///////////////////////////////////////////////////////////
-/* Structs and helper function for crawlChain for getInk */
+/* Structs and helper functions for the canvas state     */
 ///////////////////////////////////////////////////////////
-type inkAvailCrawlArgs struct {
-	miner string
+
+// A shape that is currently on the canvas.
+type liveShape struct {
+	owner string            // public key of the miner that owns the shape, and is refunded if it is deleted.
+	ink   uint32            // ink the shape used, which is refunded if it is deleted.
+	shape blockartlib.Shape // the shape itself, for overlap checks.
 }
 
-type inkAvailCrawlReply struct {
-	removedShapeHashes []string
-	ink                uint32
+// A canvas created on the network by a create canvas op.
+type canvasDef struct {
+	creator  string // public key of the miner that created the canvas.
+	settings blockartlib.CanvasSettings
+	rules    blockartlib.CanvasRules
 }
 
-// Checks the block for a shape with the passed args.deleteShapeHash
-// If the shape was ever added, set reply.shape to the shape.
-// Also count how many times the shape was added
-// This function should be used when the default behaviour of crawlChain is sufficient
-// @param: blockMeta *BlockMeta: block on which the function is called; does nothing
-// @param: args interface{]: a inkAvailCrawlArgs contianing the miner whose remaining ink we're finding
-// @param: reply interface{}: a inkAvailCrawlReply that will contain the ink remaining
-// @return done bool: wehther the shape has been found (whether deleted or not, since the search is
-//                    done in both situations)
-// @return err error: any errors encountered
-func inkAvailCrawlHelper(blockMeta *BlockMeta, args interface{}, reply interface{}) (done bool, err error) {
-	crawlArgs, ok := args.(*inkAvailCrawlArgs)
-	if !ok {
-		// args is invalid; return an error
-		return true, blockartlib.DisconnectedError("")
+// An active claim on a region of a canvas.
+type regionClaim struct {
+	canvas   string          // ID of the canvas the region is on.
+	owner    string          // public key of the miner that made the claim, and is refunded its deposit.
+	region   blockartlib.Box // region no one else may draw in.
+	deposit  uint32          // ink held until the claim expires.
+	expires  int             // length of the chain after which the claim is released.
+	grantees map[string]bool // public keys of the miners the claim's owner lets draw in the region; never modified.
+}
+
+// The canvases as of some block: the canvases created on the network, the shapes on them, the active claims
+// on them, and the ink available to each miner.
+// Every block's header commits to the state reached after applying it.
+type canvasState struct {
+	canvases map[string]canvasDef   // keyed by canvas ID; the network's own canvas is not included.
+	shapes   map[string]liveShape   // keyed by shape hash.
+	claims   map[string]regionClaim // keyed by hash of the claim op.
+	ink      map[string]uint32      // keyed by public key of the miner.
+	ops      map[string]bool        // hashes of the ops on the chain, to reject replays; not in the state root.
+
+	index *shapeIndex // spatial index of shapes; kept up to date by addShape and removeShape.
+}
+
+// Returns a copy of state that can be modified without modifying state.
+func (state *canvasState) clone() *canvasState {
+	next := &canvasState{canvases: make(map[string]canvasDef), shapes: make(map[string]liveShape),
+		claims: make(map[string]regionClaim), ink: make(map[string]uint32), ops: make(map[string]bool),
+		index: state.index.clone()}
+	for canvasID, canvas := range state.canvases {
+		next.canvases[canvasID] = canvas
+	}
+	for hash, shape := range state.shapes {
+		next.shapes[hash] = shape
+	}
+	for hash, claim := range state.claims {
+		next.claims[hash] = claim
+	}
+	for miner, ink := range state.ink {
+		next.ink[miner] = ink
 	}
+	for hash := range state.ops {
+		next.ops[hash] = true
+	}
+	return next
+}
 
-	crawlReply, ok := reply.(*inkAvailCrawlReply)
-	if !ok {
-		// reply is invalid; return an error
-		return true, blockartlib.DisconnectedError("")
+// Puts a shape on the canvas, and in the spatial index.
+// @param hash string: the shape's hash
+// @param shape liveShape: the shape
+func (state *canvasState) addShape(hash string, shape liveShape) {
+	state.shapes[hash] = shape
+	state.index.add(hash, shape.shape)
+}
+
+// Takes a shape off the canvas, and out of the spatial index.
+// @param hash string: the shape's hash; nothing happens if the shape is not on the canvas
+func (state *canvasState) removeShape(hash string) {
+	if shape, ok := state.shapes[hash]; ok {
+		delete(state.shapes, hash)
+		state.index.remove(hash, shape.shape)
 	}
+}
 
-	// for simplicity, iterate through the block twice
-	// first, look only for delete operations
-	for _, opMeta := range blockMeta.block.ops {
-		op := opMeta.op
-		if op.deleteShapeHash != "" && op.owner == crawlArgs.miner {
-			// shape was removed and owned by this miner
-			crawlReply.removedShapeHashes = append(crawlReply.removedShapeHashes, op.deleteShapeHash)
+///////////////////////////////////////////////////////////
+/* Structs and helper functions for the shape index      */
+///////////////////////////////////////////////////////////
+
+const (
+	// Side of the square cells of the grid the spatial index of shapes lays over each canvas, in pixels.
+	shapeIndexCellSize = 32
+	// Most cells a shape is listed in; larger shapes are listed as large, and returned by every lookup.
+	shapeIndexMaxCells = 64
+)
+
+const (
+	// Most positions a find-free-space query returns.
+	maxFreeSpaceResults = 100
+	// Most positions a find-free-space query tries; on larger canvases the positions are spread further apart.
+	maxFreeSpaceProbes = 10000
+)
+
+// A cell of the grid laid over a layer of a canvas.
+type shapeIndexCell struct {
+	canvas string
+	layer  uint8
+	x, y   int
+}
+
+// A layer of a canvas.
+type shapeIndexLayer struct {
+	canvas string
+	layer  uint8
+}
+
+// A spatial index of shapes: the hashes of the shapes on each layer of each canvas, listed in every cell of
+// a grid their bounding box touches, so only the shapes near a region have to be checked against it.
+type shapeIndex struct {
+	cells map[shapeIndexCell][]string  // hashes of the shapes touching each cell.
+	large map[shapeIndexLayer][]string // hashes of the shapes touching more than shapeIndexMaxCells cells.
+}
+
+// Returns an empty spatial index.
+func newShapeIndex() *shapeIndex {
+	return &shapeIndex{cells: make(map[shapeIndexCell][]string), large: make(map[shapeIndexLayer][]string)}
+}
+
+// Returns a copy of index that can be modified without modifying index.
+func (index *shapeIndex) clone() *shapeIndex {
+	next := newShapeIndex()
+	for cell, hashes := range index.cells {
+		next.cells[cell] = append([]string{}, hashes...)
+	}
+	for layer, hashes := range index.large {
+		next.large[layer] = append([]string{}, hashes...)
+	}
+	return next
+}
+
+// Lists a shape in the cells its bounding box touches, or as large if there are too many of them.
+// @param hash string: the shape's hash
+// @param shape blockartlib.Shape: the shape
+func (index *shapeIndex) add(hash string, shape blockartlib.Shape) {
+	minX, minY, maxX, maxY := shapeIndexCellRange(blockartlib.ShapeBounds(shape))
+	if (maxX-minX+1)*(maxY-minY+1) > shapeIndexMaxCells {
+		layer := shapeIndexLayer{shape.Canvas, shape.Layer}
+		index.large[layer] = append(index.large[layer], hash)
+		return
+	}
+	for x := minX; x <= maxX; x++ {
+		for y := minY; y <= maxY; y++ {
+			cell := shapeIndexCell{shape.Canvas, shape.Layer, x, y}
+			index.cells[cell] = append(index.cells[cell], hash)
 		}
 	}
+}
 
-	// second, look only for added operations
-	for _, opMeta := range blockMeta.block.ops {
-		op := opMeta.op
-		if op.deleteShapeHash == "" && op.owner == crawlArgs.miner {
-			// shape was added and owned by this miner
-			index := searchSlice(op.shapeMeta.Hash, crawlReply.removedShapeHashes)
-			if index >= 0 && index < len(crawlReply.removedShapeHashes) {
-				// shape was later removed; do not charge for ink
-				// remove deleteShapeHash from the list of removedShapeHashes
-				crawlReply.removedShapeHashes = append(crawlReply.removedShapeHashes[:index], crawlReply.removedShapeHashes[index+1:]...)
-			} else {
-				// shape was not removed
-				crawlReply.ink -= op.shapeMeta.Shape.Ink
+// Takes a shape out of the index. Cells left empty are dropped, so that the index only holds cells in use.
+// @param hash string: the shape's hash
+// @param shape blockartlib.Shape: the shape, as it was added
+func (index *shapeIndex) remove(hash string, shape blockartlib.Shape) {
+	minX, minY, maxX, maxY := shapeIndexCellRange(blockartlib.ShapeBounds(shape))
+	if (maxX-minX+1)*(maxY-minY+1) > shapeIndexMaxCells {
+		layer := shapeIndexLayer{shape.Canvas, shape.Layer}
+		if index.large[layer] = withoutHash(index.large[layer], hash); len(index.large[layer]) == 0 {
+			delete(index.large, layer)
+		}
+		return
+	}
+	for x := minX; x <= maxX; x++ {
+		for y := minY; y <= maxY; y++ {
+			cell := shapeIndexCell{shape.Canvas, shape.Layer, x, y}
+			if index.cells[cell] = withoutHash(index.cells[cell], hash); len(index.cells[cell]) == 0 {
+				delete(index.cells, cell)
 			}
 		}
 	}
+}
 
-	pub, _ := hex.DecodeString(crawlArgs.miner)
-	minerPubKey, _ := x509.ParsePKIXPublicKey(pub)
-	if ecdsa.Verify(minerPubKey.(*ecdsa.PublicKey), blockMeta.hash, &blockMeta.r, &blockMeta.s) {
-		// this block was created by the miner; add the ink you get for mining
-		if len(blockMeta.block.ops) == 0 {
-			crawlReply.ink += minerNetSettings.InkPerNoOpBlock
-		} else {
-			crawlReply.ink += minerNetSettings.InkPerOpBlock
+// Returns hashes without hash, reusing the backing array of hashes.
+func withoutHash(hashes []string, hash string) []string {
+	kept := hashes[:0]
+	for _, h := range hashes {
+		if h != hash {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+// Returns the hashes of the shapes on a layer of a canvas whose bounding box may touch the passed box,
+// which include every shape that touches it, in sorted order.
+// @param canvas string: ID of the canvas
+// @param layer uint8: the layer
+// @param box blockartlib.Box: the region looked up, including its border
+// @return []string: the hashes of the shapes
+func (index *shapeIndex) near(canvas string, layer uint8, box blockartlib.Box) []string {
+	found := make(map[string]bool)
+	for _, hash := range index.large[shapeIndexLayer{canvas, layer}] {
+		found[hash] = true
+	}
+	minX, minY, maxX, maxY := shapeIndexCellRange(box)
+	if (maxX-minX+1)*(maxY-minY+1) > len(index.cells) {
+		// the box covers more cells than are in use; go through the cells in use instead
+		for cell, hashes := range index.cells {
+			if cell.canvas == canvas && cell.layer == layer && minX <= cell.x && cell.x <= maxX && minY <= cell.y && cell.y <= maxY {
+				for _, hash := range hashes {
+					found[hash] = true
+				}
+			}
+		}
+	} else {
+		for x := minX; x <= maxX; x++ {
+			for y := minY; y <= maxY; y++ {
+				for _, hash := range index.cells[shapeIndexCell{canvas, layer, x, y}] {
+					found[hash] = true
+				}
+			}
 		}
 	}
 
-	// Continue searching down the chain
-	return false, nil
+	hashes := make([]string, 0, len(found))
+	for hash := range found {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
 }
 
-// Searches for search in slice of strings
-// @param search: element you're looking for
-// @param slice: slice you're searching over
-// @return index int: index of search in slice if it exists, otherwise -1
-func searchSlice(search string, slice []string) (index int) {
-	for i, s := range slice {
-		if s == search {
-			return i
-		}
+// Returns the cells of the index a box touches, including along its border.
+// @param box blockartlib.Box
+// @return int, int, int, int: the first and last columns, and the first and last rows, of cells touched
+func shapeIndexCellRange(box blockartlib.Box) (minX int, minY int, maxX int, maxY int) {
+	cell := func(f float64) int {
+		return int(math.Floor(f / shapeIndexCellSize))
 	}
-	return -1
+	return cell(box.MinX), cell(box.MinY), cell(box.MaxX), cell(box.MaxY)
 }
 
-// Counts the amount of ink currently available to passed miner starting at blockMeta
-// - ASSUMES that if any locks are requred for blockMeta, they have already been acquired
-// @param owner ecdsa.PublicKey: public key identfying miner
-// @param blockMeta *Block: head block of chain from which ink will be calculated
-// @return ink uint32: ink currently available to the miner, in pixels
-func inkAvail(miner string, blockMeta *BlockMeta) (ink uint32) {
-	// the crawl by default does all the work we need, so no special helper/args/reply is required
-	args := &inkAvailCrawlArgs{miner: miner}
-	var reply inkAvailCrawlReply
-
-	if err := crawlChain(blockMeta, inkAvailCrawlHelper, args, &reply); err != nil {
-		// error while searching; just return 0
-		return 0
+// Returns an empty canvas state.
+func newCanvasState() *canvasState {
+	return &canvasState{canvases: make(map[string]canvasDef), shapes: make(map[string]liveShape),
+		claims: make(map[string]regionClaim), ink: make(map[string]uint32), ops: make(map[string]bool),
+		index: newShapeIndex()}
+}
+
+// Returns the canvas with the passed ID in state. The empty ID names the network's own canvas, which
+// always exists, and has no creator and no rules.
+// @param state *canvasState: the state to look in
+// @param canvasID string: the canvas's ID
+// @return canvasDef: the canvas
+// @return bool: false iff there is no such canvas
+func canvasFor(state *canvasState, canvasID string) (canvasDef, bool) {
+	if canvasID == "" {
+		return canvasDef{settings: minerNetSettings.CanvasSettings}, true
+	}
+	canvas, ok := state.canvases[canvasID]
+	return canvas, ok
+}
+
+// Returns the canvas state after blockMeta.
+// States are computed forward from the closest ancestor whose state is cached, or from the empty canvas
+// if none is, and are then cached; see cacheCanvasState.
+// - ASSUMES that blockMeta and all of its ancestors are valid and stored in blockTree
+// LOCKS: Acquires and releases canvasStatesLock, and blockTreeLock while walking the chain
+// @param blockMeta *BlockMeta: the block after which the state is returned
+// @return *canvasState: the canvas state; must not be modified
+func canvasStateAt(blockMeta *BlockMeta) *canvasState {
+	canvasStatesLock.Lock()
+	defer canvasStatesLock.Unlock()
+
+	// walk back to a block with a known state
+	unapplied := []*BlockMeta{}
+	curr := blockMeta
+	state := newCanvasState()
+	blockTreeLock.Lock()
+	for curr != nil && !isGenesis(*curr) {
+		if known, ok := canvasStates[curr.hash.ToString()]; ok {
+			state = known.state
+			break
+		}
+		unapplied = append(unapplied, curr)
+		curr = blockTree[curr.block.prev.ToString()]
+	}
+	blockTreeLock.Unlock()
+
+	// apply blocks from oldest to newest
+	for i := len(unapplied) - 1; i >= 0; i-- {
+		state = applyBlock(state, unapplied[i].block)
+		cacheCanvasState(unapplied[i], state)
 	}
 
-	return reply.ink
+	return state
 }
 
-// Counts the amount of ink currently available to this miner starting at currBlock
-// ASSUME: you have acquired blockLock
-// @return ink uint32: ink currently available to this miner, in pixels
-func inkAvailCurr() (ink uint32) {
-	// the crawl by default does all the work we need, so no special helper/args/reply is required
-	args := &inkAvailCrawlArgs{miner: publicKeyString}
-	var reply inkAvailCrawlReply
-
-	// first count up the amount of ink used in currBlock
-	// look only for delete operations
-	for _, opMeta := range currBlock.ops {
-		op := opMeta.op
-		if op.deleteShapeHash != "" && op.owner == publicKeyString {
-			// shape was removed and owned by this miner
-			reply.removedShapeHashes = append(reply.removedShapeHashes, op.deleteShapeHash)
-		}
-	}
-	// look only for added operations
-	for _, opMeta := range currBlock.ops {
-		op := opMeta.op
-		if op.deleteShapeHash == "" && op.owner == publicKeyString {
-			// shape was added and owned by this miner
-			index := searchSlice(op.shapeMeta.Hash, reply.removedShapeHashes)
-			if index >= 0 && index < len(reply.removedShapeHashes) {
-				// shape was later removed; do not charge for ink
-				// remove deleteShapeHash from the list of removedShapeHashes
-				reply.removedShapeHashes = append(reply.removedShapeHashes[:index], reply.removedShapeHashes[index+1:]...)
-			} else {
-				// shape was not removed
-				reply.ink -= op.shapeMeta.Shape.Ink
+// A cached canvas state, and the length of the chain ending at the block it follows.
+type cachedCanvasState struct {
+	state *canvasState
+	len   int
+}
+
+// Caches the canvas state after blockMeta, and evicts the states of blocks that are canvasStateCacheDepth
+// or more blocks behind the longest chain cached, so that the cache does not grow with the chain.
+// - ASSUMES that canvasStatesLock has been acquired
+// @param blockMeta *BlockMeta: the block after which state is
+// @param state *canvasState: the canvas state after blockMeta
+func cacheCanvasState(blockMeta *BlockMeta, state *canvasState) {
+	if blockMeta.block.len+canvasStateCacheDepth <= canvasStatesTip {
+		// too old to keep; it is rebuilt if it is needed again
+		return
+	}
+	canvasStates[blockMeta.hash.ToString()] = cachedCanvasState{state: state, len: blockMeta.block.len}
+
+	if blockMeta.block.len > canvasStatesTip {
+		canvasStatesTip = blockMeta.block.len
+		for hash, cached := range canvasStates {
+			if cached.len+canvasStateCacheDepth <= canvasStatesTip {
+				delete(canvasStates, hash)
 			}
 		}
 	}
+}
 
-	// second, go through the rest of the chain
-	if err := crawlChain(headBlockMeta, inkAvailCrawlHelper, args, &reply); err != nil {
-		// error while searching; just return 0
-		return 0
+// Returns the canvas state reached by applying the block's ops, its mining reward, and the release of the
+// claims that expire with it, to state.
+// - ASSUMES that the block's ops are valid
+// @param state *canvasState: the state before the block; not modified
+// @param block Block: the block to apply
+// @return *canvasState: the state after the block
+func applyBlock(state *canvasState, block Block) *canvasState {
+	next := applyOps(state, block.ops, block.miner, block.len)
+
+	// reward the miner that mined the block
+	if len(block.ops) == 0 {
+		next.ink[block.miner] += minerNetSettings.InkPerNoOpBlock
+	} else {
+		next.ink[block.miner] += minerNetSettings.InkPerOpBlock
 	}
 
-	return reply.ink
+	// release expired claims; refund their deposits
+	for hash, claim := range next.claims {
+		if claim.expires <= block.len {
+			delete(next.claims, hash)
+			next.ink[claim.owner] += claim.deposit
+		}
+	}
+
+	return next
+}
+
+// Returns the canvas state reached by applying ops, in order, to state.
+// - ASSUMES that the ops are valid
+// @param state *canvasState: the state before the ops; not modified
+// @param ops []OpMeta: the ops to apply
+// @param miner string: public key of the miner that is paid the ops' fees; empty if they are not mined yet
+// @param blockLen int: length of the chain ending at the block holding the ops
+// @return *canvasState: the state after the ops
+func applyOps(state *canvasState, ops []OpMeta, miner string, blockLen int) *canvasState {
+	next := state.clone()
+	for _, opMeta := range ops {
+		applyOp(next, opMeta, miner, blockLen)
+	}
+	return next
+}
+
+// Applies an op to state, modifying it.
+// - ASSUMES that the op is valid
+// @param state *canvasState: the state before the op; must not be shared, e.g. a clone
+// @param opMeta OpMeta: the op to apply
+// @param miner string: public key of the miner that is paid the op's fee; empty if it is not mined yet
+// @param blockLen int: length of the chain ending at the block holding the op
+func applyOp(state *canvasState, opMeta OpMeta, miner string, blockLen int) {
+	op := opMeta.op
+	state.ops[opMeta.hash.ToString()] = true
+
+	// the fee goes from the op's owner to the block's miner
+	state.ink[op.owner] -= op.fee
+	if miner != "" {
+		state.ink[miner] += op.fee
+	}
+
+	switch op.kind {
+	case ADD_OP:
+		// shape was added; charge its owner
+		shape := op.shapeMeta.Shape
+		state.addShape(op.shapeMeta.Hash, liveShape{owner: op.owner, ink: shape.Ink, shape: shape})
+		state.ink[op.owner] -= shape.Ink
+	case DELETE_OP:
+		if shape, ok := state.shapes[op.deleteShapeHash]; ok {
+			// shape was removed; refund its owner
+			state.removeShape(op.deleteShapeHash)
+			state.ink[shape.owner] += shape.ink
+		}
+	case UPDATE_OP:
+		// old shape is refunded, and new shape is charged, so only the difference is paid
+		old := state.shapes[op.deleteShapeHash]
+		state.removeShape(op.deleteShapeHash)
+		state.ink[old.owner] += old.ink
+		shape := op.shapeMeta.Shape
+		state.addShape(op.shapeMeta.Hash, liveShape{owner: op.owner, ink: shape.Ink, shape: shape})
+		state.ink[op.owner] -= shape.Ink
+	case TRANSFER_OP:
+		state.ink[op.owner] -= op.transferAmount
+		state.ink[op.transferTo] += op.transferAmount
+	case TRANSFER_SHAPE_OP:
+		// the shape, and the right to its refund, moves to the new owner; it stays where it is in the index
+		shape := state.shapes[op.transferShapeHash]
+		shape.owner = op.transferTo
+		state.shapes[op.transferShapeHash] = shape
+	case CLAIM_OP:
+		// the deposit is held by the claim until it expires
+		state.claims[opMeta.hash.ToString()] = regionClaim{canvas: op.canvasID, owner: op.owner, region: op.claimRegion,
+			deposit: op.deposit, expires: blockLen + int(op.claimBlocks), grantees: map[string]bool{}}
+		state.ink[op.owner] -= op.deposit
+	case GRANT_OP:
+		// grantees are shared between states, so copy them before adding to them
+		claim := state.claims[op.claimHash]
+		grantees := map[string]bool{op.transferTo: true}
+		for grantee := range claim.grantees {
+			grantees[grantee] = true
+		}
+		claim.grantees = grantees
+		state.claims[op.claimHash] = claim
+	case CREATE_CANVAS_OP:
+		state.canvases[op.canvasID] = canvasDef{creator: op.owner, settings: op.canvasSettings, rules: op.canvasRules}
+	}
+}
+
+// Returns the canvas state an op is checked against: the state after the chain ending at blockMeta's
+// previous block, and after the ops before the op in blockMeta (all of them, for a new op).
+// - ASSUMES that blockMeta's previous block and all of its ancestors are valid and stored in blockTree
+// LOCKS: Acquires and releases blockTreeLock, then canvasStatesLock
+// @param blockMeta *BlockMeta: the block holding the op, or a pseudo block meta holding the ops pending before it
+// @param indexInBlock int: index of the op in blockMeta, or -1 if it is a new op
+// @return *canvasState: a copy of the canvas state, which the caller may modify; nil if blockMeta's previous block is not known
+func canvasStateForOp(blockMeta *BlockMeta, indexInBlock int) *canvasState {
+	blockTreeLock.Lock()
+	prev, ok := blockTree[blockMeta.block.prev.ToString()]
+	blockTreeLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	ops := blockMeta.block.ops
+	if indexInBlock >= 0 {
+		// only the ops before this one have been applied
+		ops = ops[:indexInBlock]
+	}
+	return applyOps(canvasStateAt(prev), ops, "", blockMeta.block.len)
+}
+
+// Returns the commitment to state stored in block headers: the Merkle root of its canvases, shapes, claims
+// and ink balances, each sorted so that every miner computes the same root.
+// @param version uint8: the block version, which selects the hashing algorithm
+// @param state *canvasState: the state to commit to
+// @return Hash: the state root
+func canvasStateRoot(version uint8, state *canvasState) blockartlib.Hash {
+	canvasIDs := []string{}
+	for canvasID := range state.canvases {
+		canvasIDs = append(canvasIDs, canvasID)
+	}
+	sort.Strings(canvasIDs)
+
+	shapeHashes := []string{}
+	for hash := range state.shapes {
+		shapeHashes = append(shapeHashes, hash)
+	}
+	sort.Strings(shapeHashes)
+
+	claimHashes := []string{}
+	for hash := range state.claims {
+		claimHashes = append(claimHashes, hash)
+	}
+	sort.Strings(claimHashes)
+
+	miners := []string{}
+	for miner := range state.ink {
+		miners = append(miners, miner)
+	}
+	sort.Strings(miners)
+
+	leaves := []blockartlib.Hash{}
+	for _, canvasID := range canvasIDs {
+		canvas := state.canvases[canvasID]
+		leaf := fmt.Sprintf("canvas|%s|%s|%d|%d|%t|%d|%d", canvasID, canvas.creator, canvas.settings.CanvasXMax,
+			canvas.settings.CanvasYMax, canvas.rules.CreatorOnly, canvas.rules.MaxShapeInk, canvas.rules.MaxLayer)
+		leaves = append(leaves, blockartlib.HashBytes(version, []byte(leaf)))
+	}
+	for _, hash := range shapeHashes {
+		leaf := fmt.Sprintf("shape|%s|%s", hash, state.shapes[hash].owner)
+		leaves = append(leaves, blockartlib.HashBytes(version, []byte(leaf)))
+	}
+	for _, hash := range claimHashes {
+		claim := state.claims[hash]
+		grantees := []string{}
+		for grantee := range claim.grantees {
+			grantees = append(grantees, grantee)
+		}
+		sort.Strings(grantees)
+		leaf := fmt.Sprintf("claim|%s|%s|%d|%s", hash, claim.owner, claim.expires, strings.Join(grantees, ","))
+		leaves = append(leaves, blockartlib.HashBytes(version, []byte(leaf)))
+	}
+	for _, miner := range miners {
+		leaf := fmt.Sprintf("ink|%s|%d", miner, state.ink[miner])
+		leaves = append(leaves, blockartlib.HashBytes(version, []byte(leaf)))
+	}
+
+	return blockartlib.MerkleRoot(version, leaves)
+}
+
+///////////////////////////////////////////////////////////
+/* Helper functions for ink                              */
+///////////////////////////////////////////////////////////
+
+// Counts the amount of ink currently available to this miner, including the ops in the mempool
+// ASSUME: you have acquired blockLock
+// @return ink uint32: ink currently available to this miner, in pixels
+func inkAvailCurr() (ink uint32) {
+	pending := pendingBlockMeta()
+	return inkAvailForOp(publicKeyString, &pending, -1)
+}
+
+// Counts the amount of ink the owner of an op has available for it: the ink after the chain ending at
+// blockMeta's previous block, and after the ops before the op in blockMeta.
+// - ASSUMES that if any locks are requred for the chain, they have already been acquired
+// @param owner string: public key of the op's owner
+// @param blockMeta *BlockMeta: the block holding the op, or a pseudo block meta holding the ops pending before it
+// @param indexInBlock int: index of the op in blockMeta, or -1 if it is a new op
+// @return ink uint32: ink available to the owner, in pixels
+func inkAvailForOp(owner string, blockMeta *BlockMeta, indexInBlock int) (ink uint32) {
+	state := canvasStateForOp(blockMeta, indexInBlock)
+	if state == nil {
+		// previous block is not known; just return 0
+		return 0
+	}
+	return state.ink[owner]
 }
 
 /*
-	Registering the miner to the server, calling the server's RPC method
-	@return error: ServerConnectionError if connection to server fails
+	Registering the miner to the server, calling the server's RPC method, and loading the network settings
+	it returns
+	@return error: ServerConnectionError if connection to server fails; InvalidColorError or
+	               DuplicateColorError if the settings' ink pricing is invalid
 */
 func registerMinerToServer() error {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", incomingAddress)
@@ -1539,6 +3326,13 @@ func registerMinerToServer() error {
 	if clientErr != nil {
 		return ServerConnectionError("registration failure ")
 	}
+
+	// prices are looked up by canonical color, and every miner must charge the same ink for a shape
+	pricing, err := minerNetSettings.InkPricing.Normalize()
+	if err != nil {
+		return err
+	}
+	minerNetSettings.InkPricing = pricing
 	return nil
 }
 
@@ -1575,6 +3369,7 @@ func getNodes() error {
 	}
 
 	neighboursLock.Lock()
+	addedNeighbour := false
 	for _, address := range newNeighbourAddresses {
 		if inkMiner := addNeighbour(address); inkMiner != nil {
 			// notify new neighbours that you are their neighbour
@@ -1583,10 +3378,17 @@ func getNodes() error {
 			if !reply {
 				// remove neighbour from map
 				delete(neighbours, address)
+			} else {
+				addedNeighbour = true
 			}
 		}
 	}
 	neighboursLock.Unlock()
+
+	if addedNeighbour {
+		// new neighbours may know of a longer chain
+		go syncChain()
+	}
 	return nil
 }
 
@@ -1650,6 +3452,227 @@ func requestForMoreNodesRoutine() error {
 	return nil
 }
 
+/*
+	Synchronizes this miner's chain with its neighbours', headers first:
+	1. downloads the header chain of each neighbour by height range, and verifies it
+	2. picks the longest valid header chain
+	3. downloads the blocks missing locally, in parallel from the neighbours that have that chain
+	4. validates the blocks in order, and adopts the chain if it is longer than the current one
+	Only one sync runs at a time; neighboursLock is not held while neighbours are contacted.
+*/
+func syncChain() {
+	syncingLock.Lock()
+	if syncing {
+		syncingLock.Unlock()
+		return
+	}
+	syncing = true
+	syncingLock.Unlock()
+	defer func() {
+		syncingLock.Lock()
+		syncing = false
+		syncingLock.Unlock()
+	}()
+
+	neighboursLock.Lock()
+	peers := []InkMiner{}
+	for _, n := range neighbours {
+		peers = append(peers, n)
+	}
+	neighboursLock.Unlock()
+
+	// 1. download and verify each neighbour's header chain
+	var best []blockartlib.BlockHeader
+	chains := make(map[string][]blockartlib.BlockHeader)
+	for _, peer := range peers {
+		headers, err := requestHeaderChain(peer)
+		if err != nil {
+			fmt.Printf("Sync: could not get headers from %s: %v\n", peer.address.String(), err)
+			continue
+		}
+		fmt.Printf("Sync: %s has a chain of length %d\n", peer.address.String(), len(headers))
+		chains[peer.address.String()] = headers
+
+		// 2. pick the longest chain
+		if len(headers) > len(best) {
+			best = headers
+		}
+	}
+
+	headBlockLock.Lock()
+	headLen := headBlockMeta.block.len
+	headBlockLock.Unlock()
+	if len(best) <= headLen {
+		// no neighbour knows of a longer chain
+		return
+	}
+	bestHead := best[len(best)-1].Hash
+
+	// 3. download the missing blocks from the neighbours that have the chain
+	missing := []blockartlib.BlockHeader{}
+	for _, header := range best {
+		blockTreeLock.Lock()
+		_, exists := blockTree[header.Hash]
+		blockTreeLock.Unlock()
+		if !exists {
+			missing = append(missing, header)
+		}
+	}
+	sources := []InkMiner{}
+	for _, peer := range peers {
+		if headers := chains[peer.address.String()]; len(headers) == len(best) && headers[len(headers)-1].Hash == bestHead {
+			sources = append(sources, peer)
+		}
+	}
+	fmt.Printf("Sync: downloading %d blocks from %d neighbours\n", len(missing), len(sources))
+	bodies := downloadBlocks(missing, sources)
+
+	// 4. validate the blocks, oldest first, and adopt the chain
+	var tip *BlockMeta
+	for i, blockMeta := range bodies {
+		if blockMeta == nil {
+			fmt.Printf("Sync: could not download block %s\n", missing[i].Hash)
+			break
+		}
+
+		blockTreeLock.Lock()
+		parent, ok := blockTree[blockMeta.block.prev.ToString()]
+		blockTreeLock.Unlock()
+		if !ok {
+			break
+		}
+		if err := validateBlock([]*BlockMeta{blockMeta, parent}); err != nil {
+			fmt.Printf("Sync: block %s is not valid: %v\n", missing[i].Hash, err)
+			break
+		}
+
+		blockTreeLock.Lock()
+		blockTree[blockMeta.hash.ToString()] = blockMeta
+		blockTreeLock.Unlock()
+		tip = blockMeta
+	}
+
+	if tip != nil {
+		updateHead(tip)
+		fmt.Printf("Sync: head is %s at length %d\n", tip.hash.ToString(), tip.block.len)
+	}
+}
+
+/*
+	Downloads and verifies the header chain of a neighbour, by height range
+	@param: the neighbour
+	@return: the neighbour's headers from the block after the genesis block to its head;
+	         error if the neighbour could not be reached, or its chain is not valid
+*/
+func requestHeaderChain(peer InkMiner) ([]blockartlib.BlockHeader, error) {
+	headers := []blockartlib.BlockHeader{}
+	for {
+		args := RequestHeadersArgs{From: len(headers) + 1, To: len(headers) + maxHeadersPerRequest}
+		var reply RequestHeadersReply
+		if err := peer.conn.Call("MinMin.RequestHeaders", &args, &reply); err != nil {
+			return nil, err
+		}
+		headers = append(headers, reply.Headers...)
+		if len(reply.Headers) < maxHeadersPerRequest || len(headers) >= reply.HeadLen {
+			break
+		}
+	}
+
+	if err := blockartlib.VerifyHeaderChain(headers, verificationSettings()); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+/*
+	Downloads the blocks with the given headers, in parallel from the given neighbours.
+	Blocks are requested in batches of up to maxBlocksPerRequest. Each neighbour serves batches
+	until it fails, at which point the rest of its batch is left for the others.
+	@param: the headers of the blocks to download
+	@param: the neighbours to download from
+	@return: the blocks, in the same order as the headers; nil where a block could not be downloaded
+*/
+func downloadBlocks(headers []blockartlib.BlockHeader, sources []InkMiner) []*BlockMeta {
+	bodies := make([]*BlockMeta, len(headers))
+	if len(headers) == 0 {
+		return bodies
+	}
+
+	// each job is the index of the first block of a batch
+	jobs := make(chan int, len(headers))
+	for i := 0; i < len(headers); i += maxBlocksPerRequest {
+		jobs <- i
+	}
+
+	remaining := len(headers)
+	progressLock := &sync.Mutex{}
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source InkMiner) {
+			defer wg.Done()
+			for start := range jobs {
+				end := start + maxBlocksPerRequest
+				if end > len(headers) {
+					end = len(headers)
+				}
+				args := RequestBlocksArgs{}
+				for _, header := range headers[start:end] {
+					args.Hashes = append(args.Hashes, header.Hash)
+				}
+
+				var reply RequestBlocksReply
+				err := source.conn.Call("MinMin.RequestBlocks", &args, &reply)
+
+				// the reply may stop early because of size limits; keep the blocks that match
+				received := 0
+				for err == nil && received < len(reply.Blocks) {
+					blockMeta := reply.Blocks[received]
+					hash := headers[start+received].Hash
+					if blockMeta.hash.ToString() != hash || hashBlock(blockMeta.block).ToString() != hash {
+						break
+					}
+					bodies[start+received] = blockMeta
+					received++
+				}
+
+				progressLock.Lock()
+				remaining -= received
+				fmt.Printf("Sync: downloaded %d/%d blocks\n", len(headers)-remaining, len(headers))
+				if remaining == 0 {
+					close(jobs)
+				}
+				progressLock.Unlock()
+
+				if start+received < end {
+					// leave the rest of the batch for the others
+					jobs <- start + received
+					if received == 0 {
+						// this neighbour can't serve the blocks
+						return
+					}
+				}
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	return bodies
+}
+
+// Returns the network settings that block headers are verified against.
+// @return blockartlib.MinerNetSettings: the settings
+func verificationSettings() blockartlib.MinerNetSettings {
+	return blockartlib.MinerNetSettings{
+		GenesisBlockHash:       minerNetSettings.GenesisBlockHash,
+		PoWDifficultyOpBlock:   minerNetSettings.PoWDifficultyOpBlock,
+		PoWDifficultyNoOpBlock: minerNetSettings.PoWDifficultyNoOpBlock,
+		BlockVersion:           networkBlockVersion(),
+		CanvasSettings:         minerNetSettings.CanvasSettings,
+		InkPricing:             minerNetSettings.InkPricing,
+	}
+}
+
 /*
 	Tries to find a nonce such that the hash of the block has the correct
 	number of trailing zeros
@@ -1673,6 +3696,31 @@ func mine() {
 	for {
 		// acquire lock
 		blockLock.Lock()
+
+		// fill the block from the mempool, if the mempool has changed since it was last filled
+		expireMempool(time.Now())
+		if pool.changed {
+			currBlock.ops = blockTemplate()
+			pool.changed = false
+		}
+
+		// the block being mined on; this is the head block, but headBlockLock can't be taken while
+		// holding blockLock, as updateHead takes them in the other order
+		blockTreeLock.Lock()
+		prev := blockTree[currBlock.prev.ToString()]
+		blockTreeLock.Unlock()
+
+		// commit to the block's ops, and to the canvas they produce
+		currBlock.merkleRoot = opsMerkleRoot(currBlock.version, currBlock.ops)
+		currState := applyBlock(canvasStateAt(prev), *currBlock)
+		currBlock.stateRoot = canvasStateRoot(currBlock.version, currState)
+
+		// stamp the block; it must be later than the median of the blocks before it
+		currBlock.timestamp = time.Now().UnixNano()
+		if medianTime := medianTimePast(prev); currBlock.timestamp <= medianTime {
+			currBlock.timestamp = medianTime + 1
+		}
+
 		for i := 0; i < numTries; i++ {
 			currBlock.nonce = strconv.Itoa(nonceTry)
 			nonceTry++
@@ -1696,18 +3744,15 @@ func mine() {
 				// the RPC call does the work we need, so just call it from within this miner
 				m := new(MinMin)
 				var reply bool
-				// if it's successful, we want to start with a clean block
-				// if it's unsuccessful, it's the responsability of op routines to add back
-				// operations, at which point they will be re-validated (but this case should
-				// never actually happen)
+				// if it's successful, the block becomes the head and updateHead moves currBlock on top
+				// of it, keeping any ops that arrived in the meantime
+				// if it's unsuccessful, currBlock is left as is, and is mined again (but this case
+				// should never actually happen)
 				// in both cases, we don't care about the result
 				// NotifyNewBlock needs the blockLock, so give it up
-				// blockLock.Unlock()
+				blockLock.Unlock()
 				m.NotifyNewBlock(newBlockMeta, &reply)
-
-				// regardless of result, new block should always point to new block
-				// blockLock.Lock()
-				currBlock = &Block{prev: headBlockMeta.hash, len: headBlockMeta.block.len + 1, miner: publicKeyString}
+				blockLock.Lock()
 
 				break
 			}
@@ -1781,6 +3826,13 @@ func main() {
 	gob.Register(blockartlib.ShapeOverlapError(""))
 	gob.Register(blockartlib.InvalidBlockHashError(""))
 	gob.Register(blockartlib.OutOfBoundsError{})
+	gob.Register(blockartlib.MempoolFullError(""))
+	gob.Register(blockartlib.InvalidTransferError(""))
+	gob.Register(blockartlib.RegionClaimedError(""))
+	gob.Register(blockartlib.InvalidClaimError(""))
+	gob.Register(blockartlib.InvalidCanvasError(""))
+	gob.Register(blockartlib.InvalidColorError(""))
+	gob.Register(blockartlib.InvalidTransformError(""))
 
 
 	client, err := rpc.Dial("tcp", outgoingAddress)
@@ -1820,8 +3872,9 @@ func main() {
 	incomingAddress = ml.Addr().String()
 
 	// Register miner's incomingAddress
-	if registerMinerToServer() != nil {
+	if err := registerMinerToServer(); err != nil {
 		// cannot proceed if it is not register to the server
+		fmt.Println(err)
 		return
 	}
 
@@ -1833,7 +3886,7 @@ func main() {
 	}
 	hash := blockartlib.Hash(genesisHash)
 
-	currBlock = &Block{prev: hash, len: 1, miner: publicKeyString}
+	currBlock = &Block{version: networkBlockVersion(), prev: hash, len: 1, miner: publicKeyString}
 
 	// create genesis block
 	genesisBlockMeta := &BlockMeta{hash: hash}
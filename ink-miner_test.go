@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"./blockartlib"
+	"./proj1-server/rpcCommunication"
+)
+
+// Returns a filled rectangle on a layer of a canvas, owned by owner.
+func testRectangle(owner string, canvasID string, layer uint8, box blockartlib.Box) liveShape {
+	corners := []blockartlib.Point{
+		{X: box.MinX, Y: box.MinY}, {X: box.MaxX, Y: box.MinY}, {X: box.MaxX, Y: box.MaxY}, {X: box.MinX, Y: box.MaxY},
+	}
+	shape := blockartlib.Shape{FilledIn: true, Canvas: canvasID, Layer: layer}
+	for i := range corners {
+		shape.Edges = append(shape.Edges, blockartlib.Edge{Start: corners[i], End: corners[(i+1)%len(corners)]})
+	}
+	return liveShape{owner: owner, shape: shape}
+}
+
+func TestShapeIndex(t *testing.T) {
+	index := newShapeIndex()
+	spanning := testRectangle("a", "", 0, blockartlib.Box{MinX: 30, MinY: 30, MaxX: 40, MaxY: 40})
+	index.add("spanning", spanning.shape)
+	index.add("other layer", testRectangle("a", "", 1, blockartlib.Box{MinX: 30, MinY: 30, MaxX: 40, MaxY: 40}).shape)
+	large := testRectangle("a", "", 0, blockartlib.Box{MinX: 0, MinY: 0, MaxX: 1000, MaxY: 1000})
+	index.add("large", large.shape)
+
+	// Case 1: A shape that spans cell boundaries is found from every cell it touches, and from no other cell
+	for _, box := range []blockartlib.Box{{MinX: 0, MinY: 0, MaxX: 10, MaxY: 10}, {MinX: 33, MinY: 33, MaxX: 35, MaxY: 35}} {
+		if hashes := index.near("", 0, box); !reflect.DeepEqual(hashes, []string{"large", "spanning"}) {
+			t.Errorf("Expected [large spanning] near %v, got %v\n", box, hashes)
+		}
+	}
+	if hashes := index.near("", 0, blockartlib.Box{MinX: 70, MinY: 70, MaxX: 90, MaxY: 90}); !reflect.DeepEqual(hashes, []string{"large"}) {
+		t.Errorf("Expected only [large] away from the spanning shape, got %v\n", hashes)
+	}
+	// Case 2: Shapes on other layers and canvases are not found
+	if hashes := index.near("", 1, blockartlib.Box{MinX: 0, MinY: 0, MaxX: 100, MaxY: 100}); !reflect.DeepEqual(hashes, []string{"other layer"}) {
+		t.Errorf("Expected only [other layer] on layer 1, got %v\n", hashes)
+	}
+	if hashes := index.near("other canvas", 0, blockartlib.Box{MinX: 0, MinY: 0, MaxX: 100, MaxY: 100}); len(hashes) != 0 {
+		t.Errorf("Expected no shapes on another canvas, got %v\n", hashes)
+	}
+	// Case 3: A clone is not modified with the index it was cloned from
+	clone := index.clone()
+	index.remove("spanning", spanning.shape)
+	index.remove("large", large.shape)
+	if hashes := clone.near("", 0, blockartlib.Box{MinX: 0, MinY: 0, MaxX: 10, MaxY: 10}); !reflect.DeepEqual(hashes, []string{"large", "spanning"}) {
+		t.Errorf("Expected the clone to keep [large spanning], got %v\n", hashes)
+	}
+	// Case 4: Removed shapes are no longer found, and the cells and layers they leave empty are dropped
+	if hashes := index.near("", 0, blockartlib.Box{MinX: 0, MinY: 0, MaxX: 100, MaxY: 100}); len(hashes) != 0 {
+		t.Errorf("Expected no shapes on layer 0 after removing them, got %v\n", hashes)
+	}
+	if len(index.cells) != 4 || len(index.large) != 0 {
+		t.Errorf("Expected only the 4 cells of the other layer's shape to remain, got %d cells and %d large layers\n", len(index.cells), len(index.large))
+	}
+}
+
+func TestFindFreeSpace(t *testing.T) {
+	minerNetSettings = &rpcCommunication.MinerNetSettings{GenesisBlockHash: "00",
+		CanvasSettings: blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}}
+	publicKeyString = "me"
+	state := newCanvasState()
+	state.canvases["c"] = canvasDef{settings: blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100},
+		rules: blockartlib.CanvasRules{MaxLayer: 1}}
+	state.addShape("theirs", testRectangle("a", "c", 0, blockartlib.Box{MinX: 0, MinY: 0, MaxX: 20, MaxY: 20}))
+	state.addShape("mine", testRectangle("me", "c", 0, blockartlib.Box{MinX: 40, MinY: 0, MaxX: 60, MaxY: 20}))
+	state.addShape("theirs on layer 1", testRectangle("a", "c", 1, blockartlib.Box{MinX: 80, MinY: 0, MaxX: 100, MaxY: 20}))
+	state.claims["claim"] = regionClaim{canvas: "c", owner: "a", region: blockartlib.Box{MinX: 0, MinY: 40, MaxX: 30, MaxY: 70}, expires: 10}
+
+	head := &BlockMeta{hash: blockartlib.Hash([]byte{1}), block: Block{len: 1}}
+	canvasStates = make(map[string]cachedCanvasState)
+	canvasStatesTip = 0
+	canvasStatesLock.Lock()
+	cacheCanvasState(head, state)
+	canvasStatesLock.Unlock()
+	headBlockLock.Lock()
+	headBlockMeta = head
+	headBlockLock.Unlock()
+
+	// Case 1: Positions overlapping someone else's shape on the layer, or someone else's claim, are left out;
+	// the caller's own shapes and shapes on other layers don't matter
+	expected := map[uint8][]blockartlib.Point{
+		0: {{X: 32, Y: 0}, {X: 64, Y: 0}, {X: 32, Y: 32}, {X: 64, Y: 32}, {X: 32, Y: 64}, {X: 64, Y: 64}},
+		1: {{X: 0, Y: 0}, {X: 32, Y: 0}, {X: 32, Y: 32}, {X: 64, Y: 32}, {X: 32, Y: 64}, {X: 64, Y: 64}},
+	}
+	for layer, positions := range expected {
+		var reply blockartlib.FindFreeSpaceReply
+		new(LibMin).FindFreeSpaceIM(&blockartlib.FindFreeSpaceArgs{CanvasID: "c", Layer: layer, Width: 20, Height: 20}, &reply)
+		if reply.Error != nil || !reflect.DeepEqual(reply.Positions, positions) {
+			t.Errorf("Expected positions %v on layer %d, got %v (error %v)\n", positions, layer, reply.Positions, reply.Error)
+		}
+	}
+	// Case 2: A claimed region is free to the miners its owner granted it to
+	box := blockartlib.Box{MinX: 0, MinY: 32, MaxX: 20, MaxY: 52}
+	if isBoxFree(box, "c", 0, "me", state) {
+		t.Errorf("Expected a box in a claimed region not to be free\n")
+	}
+	claim := state.claims["claim"]
+	claim.grantees = map[string]bool{"me": true}
+	state.claims["claim"] = claim
+	if !isBoxFree(box, "c", 0, "me", state) {
+		t.Errorf("Expected a box in a region granted to the miner to be free\n")
+	}
+	// Case 3: Boxes that don't fit on the canvas are rejected
+	var reply blockartlib.FindFreeSpaceReply
+	new(LibMin).FindFreeSpaceIM(&blockartlib.FindFreeSpaceArgs{CanvasID: "c", Width: 101, Height: 20}, &reply)
+	if _, ok := reply.Error.(blockartlib.OutOfBoundsError); !ok {
+		t.Errorf("Expected OutOfBoundsError for a box wider than the canvas, got %v\n", reply.Error)
+	}
+	// Case 4: Layers the canvas's rules don't allow are rejected
+	for _, args := range []blockartlib.FindFreeSpaceArgs{{CanvasID: "c", Layer: 2}, {Layer: 1}} {
+		args.Width, args.Height = 20, 20
+		reply = blockartlib.FindFreeSpaceReply{}
+		new(LibMin).FindFreeSpaceIM(&args, &reply)
+		if _, ok := reply.Error.(blockartlib.InvalidCanvasError); !ok {
+			t.Errorf("Expected InvalidCanvasError for layer %d of canvas %q, got %v\n", args.Layer, args.CanvasID, reply.Error)
+		}
+	}
+}
+
+// Returns the shape drawn by an svg path or text, filled and stroked as passed, with its ink and hash.
+func testShapeMeta(t *testing.T, svg string, isText bool, fill string) *blockartlib.ShapeMeta {
+	var shape *blockartlib.Shape
+	var err error
+	if isText {
+		shape, err = blockartlib.ParseSvgText(svg)
+	} else {
+		shape, err = blockartlib.ParseSvgPath(svg)
+	}
+	if err != nil {
+		t.Fatalf("Received error %v for %s\n", err, svg)
+	}
+	shape.Svg = svg
+	shape.FilledIn = fill != blockartlib.TRANSPARENT
+	shape.FillColor = fill
+	shape.BorderColor = "#ff0000"
+	if shape.Ink, err = blockartlib.InkUsed(shape); err != nil {
+		t.Fatalf("Received error %v for %s\n", err, svg)
+	}
+	return &blockartlib.ShapeMeta{Hash: blockartlib.HashShape(*shape, networkBlockVersion()), Shape: *shape}
+}
+
+func TestValidateShapeSelfIntersecting(t *testing.T) {
+	minerNetSettings = &rpcCommunication.MinerNetSettings{GenesisBlockHash: "00",
+		CanvasSettings: blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}}
+
+	// Case 1: A stroked path that crosses itself is rejected
+	if err := validateShape(testShapeMeta(t, "M 0 0 L 10 10 M 0 10 L 10 0", false, blockartlib.TRANSPARENT)); err == nil {
+		t.Errorf("Expected error for a stroked path that crosses itself\n")
+	}
+	// Case 2: A filled path that crosses itself is filled as its fill rule says, and text's strokes may meet
+	if err := validateShape(testShapeMeta(t, "M 0 0 L 10 10 L 10 0 L 0 10 Z", false, "#0000ff")); err != nil {
+		t.Errorf("Received error %v for a filled path that crosses itself\n", err)
+	}
+	if err := validateShape(testShapeMeta(t, "10,20,12,T", true, blockartlib.TRANSPARENT)); err != nil {
+		t.Errorf("Received error %v for text\n", err)
+	}
+	// Case 3: A stroked path that doesn't cross itself is accepted
+	if err := validateShape(testShapeMeta(t, "M 0 0 L 10 10 L 10 0", false, blockartlib.TRANSPARENT)); err != nil {
+		t.Errorf("Received error %v for a simple stroked path\n", err)
+	}
+}
+
+func TestVerifyNewShapeLayer(t *testing.T) {
+	minerNetSettings = &rpcCommunication.MinerNetSettings{GenesisBlockHash: "00",
+		CanvasSettings: blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}}
+	state := newCanvasState()
+	state.canvases["c"] = canvasDef{settings: blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100},
+		rules: blockartlib.CanvasRules{MaxLayer: 2}}
+	verify := func(canvasID string, layer uint8) error {
+		shapeMeta := testShapeMeta(t, "M 0 0 L 10 10", false, blockartlib.TRANSPARENT)
+		shapeMeta.Shape.Canvas = canvasID
+		shapeMeta.Shape.Layer = layer
+		shapeMeta.Hash = blockartlib.HashShape(shapeMeta.Shape, networkBlockVersion())
+		return verifyNewShape(shapeMeta, "me", state)
+	}
+
+	// Case 1: The network's own canvas only has the base layer
+	if err := verify("", 0); err != nil {
+		t.Errorf("Received error %v for the base layer\n", err)
+	}
+	if _, ok := verify("", 1).(blockartlib.InvalidCanvasError); !ok {
+		t.Errorf("Expected InvalidCanvasError for layer 1 of the network's canvas\n")
+	}
+	// Case 2: A created canvas has the layers its rules allow
+	for layer := uint8(0); layer <= 2; layer++ {
+		if err := verify("c", layer); err != nil {
+			t.Errorf("Received error %v for layer %d\n", err, layer)
+		}
+	}
+	if _, ok := verify("c", 3).(blockartlib.InvalidCanvasError); !ok {
+		t.Errorf("Expected InvalidCanvasError for a layer above the canvas's highest\n")
+	}
+}
+
+// Returns a new key pair, and its public key encoded as miners identify each other.
+func testKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Received error %v generating a key\n", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Received error %v encoding a key\n", err)
+	}
+	return key, hex.EncodeToString(pub)
+}
+
+func TestCheckOpReplay(t *testing.T) {
+	minerNetSettings = &rpcCommunication.MinerNetSettings{GenesisBlockHash: "00",
+		CanvasSettings: blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}}
+	key, owner := testKey(t)
+	_, to := testKey(t)
+	state := newCanvasState()
+	state.ink[owner] = 100
+
+	op := Op{kind: TRANSFER_OP, owner: owner, transferTo: to, transferAmount: 10, timestamp: 1}
+	hash := hashOp(op)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash)
+	if err != nil {
+		t.Fatalf("Received error %v signing the op\n", err)
+	}
+	opMeta := OpMeta{hash: hash, r: *r, s: *s, op: op}
+
+	// Case 1: An op that is not on the chain is valid
+	if err := checkOp(opMeta, state); err != nil {
+		t.Errorf("Received error %v for a new transfer\n", err)
+	}
+	// Case 2: Once the op is applied, it is remembered and can't be replayed, even by a copy of the state
+	applyOp(state, opMeta, "", 1)
+	if !state.ops[hash.ToString()] {
+		t.Errorf("Expected the applied op's hash to be in the state\n")
+	}
+	if _, ok := checkOp(opMeta, state.clone()).(blockartlib.InvalidTransferError); !ok {
+		t.Errorf("Expected InvalidTransferError for a replayed transfer\n")
+	}
+}
+
+func TestEmitReorgDoesNotBlock(t *testing.T) {
+	head := &BlockMeta{hash: blockartlib.Hash([]byte{1})}
+	event := reorgEvent{oldHead: head, newHead: head, ancestor: head}
+	full := make(chan reorgEvent, 1)
+	full <- event
+	empty := make(chan reorgEvent, 1)
+	reorgChansLock.Lock()
+	reorgChans = map[string](chan reorgEvent){"full": full, "empty": empty}
+	reorgChansLock.Unlock()
+
+	// Case 1: A channel that already holds an event is skipped, and one with room gets the event
+	emitReorg(event)
+	if len(full) != 1 || len(empty) != 1 {
+		t.Errorf("Expected both channels to hold one event, got %d and %d\n", len(full), len(empty))
+	}
+}
+
+func TestVerifyShapeTransferOverlap(t *testing.T) {
+	minerNetSettings = &rpcCommunication.MinerNetSettings{GenesisBlockHash: "00",
+		CanvasSettings: blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}}
+	_, owner := testKey(t)
+	_, to := testKey(t)
+	state := newCanvasState()
+	state.addShape("given", testRectangle(owner, "", 0, blockartlib.Box{MinX: 0, MinY: 0, MaxX: 20, MaxY: 20}))
+	state.addShape("kept", testRectangle(owner, "", 0, blockartlib.Box{MinX: 10, MinY: 10, MaxX: 30, MaxY: 30}))
+	state.addShape("kept on layer 1", testRectangle(owner, "", 1, blockartlib.Box{MinX: 60, MinY: 60, MaxX: 80, MaxY: 80}))
+	state.addShape("apart", testRectangle(owner, "", 0, blockartlib.Box{MinX: 60, MinY: 60, MaxX: 80, MaxY: 80}))
+	transfer := func(shapeHash string) error {
+		op := Op{kind: TRANSFER_SHAPE_OP, owner: owner, transferTo: to, transferShapeHash: shapeHash}
+		return verifyShapeTransfer(OpMeta{hash: hashOp(op), op: op}, state)
+	}
+
+	// Case 1: A shape that overlaps another of the owner's shapes on its layer can't be handed over
+	if _, ok := transfer("given").(blockartlib.ShapeOverlapError); !ok {
+		t.Errorf("Expected ShapeOverlapError for a shape overlapping another of the owner's\n")
+	}
+	// Case 2: The owner's shapes on other layers don't matter, nor does the shape itself
+	if err := transfer("apart"); err != nil {
+		t.Errorf("Received error %v for a shape overlapping only the owner's shape on another layer\n", err)
+	}
+}
@@ -59,6 +59,12 @@ type MinerNetSettings struct {
 	PoWDifficultyOpBlock   uint8
 	PoWDifficultyNoOpBlock uint8
 
+	// Block header version, which selects the hashing algorithm (0 is treated as blockartlib.BlockVersionMD5)
+	BlockVersion uint8
+
 	// Canvas settings
 	CanvasSettings blockartlib.CanvasSettings
+
+	// Prices of ink by color
+	InkPricing blockartlib.InkPricing
 }
@@ -58,6 +58,14 @@ type CanvasSettings struct {
 	CanvasYMax uint32 `json:"canvas-y-max"`
 }
 
+// Prices of ink by color, as percentages of the base price of one unit of ink per pixel.
+type InkPricing struct {
+	// Price of the colors not in Colors; 0 is treated as 100.
+	DefaultPercent uint32 `json:"default-percent"`
+	// Prices of particular colors, keyed by name, hex or rgb() form.
+	Colors map[string]uint32 `json:"colors"`
+}
+
 type MinerSettings struct {
 	// Hash of the very first (empty) block in the chain.
 	GenesisBlockHash string `json:"genesis-block-hash"`
@@ -98,8 +106,15 @@ type MinerNetSettings struct {
 	PoWDifficultyOpBlock   uint8 `json:"pow-difficulty-op-block"`
 	PoWDifficultyNoOpBlock uint8 `json:"pow-difficulty-no-op-block"`
 
+	// Block header version, which selects the hashing algorithm:
+	// 1 (or unset) for MD5, 2 for SHA-256.
+	BlockVersion uint8 `json:"block-version"`
+
 	// Canvas settings
 	CanvasSettings CanvasSettings `json:"canvas-settings"`
+
+	// Prices of ink by color; colors cost the same if unset.
+	InkPricing InkPricing `json:"ink-pricing"`
 }
 
 type RServer int